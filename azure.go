@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI api-version used unless
+// api_version is configured
+const defaultAzureAPIVersion = "2024-02-01"
+
+// azureURL returns the chat completions endpoint of an Azure OpenAI
+// deployment. api_url may be the full endpoint, the deployment URL
+// (.../openai/deployments/<name>), or just the resource URL, in which case
+// the model is used as the deployment name. The api-version parameter is
+// added unless api_url already has one.
+func azureURL(config *Config) (string, error) {
+	if config.APIURL == "" {
+		return "", fmt.Errorf("使用 azure 时需要配置 api_url，如 https://<资源名>.openai.azure.com/openai/deployments/<部署名>")
+	}
+	u, err := url.Parse(config.APIURL)
+	if err != nil {
+		return "", fmt.Errorf("api_url 无效: %w", err)
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(path, "/chat/completions") {
+		if !strings.Contains(path, "/deployments/") {
+			path += "/openai/deployments/" + url.PathEscape(config.Model)
+		}
+		path += "/chat/completions"
+	}
+	u.Path = path
+
+	query := u.Query()
+	if query.Get("api-version") == "" && config.APIVersion != "" {
+		query.Set("api-version", config.APIVersion)
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// benchRun is the measurement of a single benchmark request
+type benchRun struct {
+	Latency   time.Duration
+	FirstByte time.Duration // Zero for non-streaming requests
+	Tokens    int
+	Err       error
+}
+
+// benchSummary aggregates the runs; durations are in seconds
+type benchSummary struct {
+	Provider     string  `json:"provider"`
+	Model        string  `json:"model"`
+	Stream       bool    `json:"stream"`
+	Runs         int     `json:"runs"`
+	Failed       int     `json:"failed"`
+	LatencyP50   float64 `json:"latency_p50"`
+	LatencyP95   float64 `json:"latency_p95"`
+	AvgFirstByte float64 `json:"avg_time_to_first_token,omitempty"`
+	AvgTokensSec float64 `json:"avg_tokens_per_sec"`
+}
+
+// runBench implements "wen bench --runs N <prompt>". It sends the prompt N
+// times one after another and reports latency percentiles, time to first
+// token and throughput. It returns the process exit code.
+func runBench(args []string) int {
+	runs := 5
+	opts, words, err := parseArgs(args, func(fs *flag.FlagSet) {
+		fs.IntVar(&runs, "runs", runs, "bench: 请求次数")
+	})
+	if err != nil || len(words) == 0 || runs < 1 {
+		fmt.Fprintln(os.Stderr, "使用方式: ./wen bench [--runs N] [--json] <问题>")
+		return 1
+	}
+	if err := startProfiling(opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer stopProfiling()
+	defer flushTelemetry()
+
+	config, err := loadDefaultConfig(opts.ConfigFile, opts.Profile, opts.EnvFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法加载配置文件: %v\n", err)
+		return 1
+	}
+	if err := applyOptions(config, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config.Silent = true
+	config.Cache = false // Every run must reach the provider
+	question := strings.Join(words, " ")
+
+	results := make([]benchRun, 0, runs)
+	for i := 0; i < runs; i++ {
+		r := benchOnce(question, config)
+		if !opts.JSON {
+			if r.Err != nil {
+				fmt.Fprintf(os.Stderr, "第 %d 次: 失败: %v\n", i+1, r.Err)
+			} else {
+				fmt.Fprintf(os.Stderr, "第 %d 次: %.2f 秒\n", i+1, r.Latency.Seconds())
+			}
+		}
+		results = append(results, r)
+	}
+
+	summary := summarizeBench(results, config)
+	if opts.JSON {
+		data, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		printBenchSummary(summary)
+	}
+
+	if summary.Failed == summary.Runs {
+		return 1
+	}
+	return 0
+}
+
+// benchOnce sends one request without printing the answer
+func benchOnce(question string, config *Config) benchRun {
+	c := *config
+	start := time.Now()
+	var r benchRun
+
+	var answer string
+	if c.Stream {
+		c.OnDelta = func(text string) {
+			if r.FirstByte == 0 {
+				r.FirstByte = time.Since(start)
+			}
+		}
+		answer, r.Err = streamAI(question, &c)
+	} else {
+		answer, r.Err = askAI(question, &c)
+	}
+
+	r.Latency = time.Since(start)
+	r.Tokens = estimateTokens(answer)
+	return r
+}
+
+// summarizeBench computes the statistics over the successful runs
+func summarizeBench(results []benchRun, config *Config) benchSummary {
+	s := benchSummary{
+		Provider: config.Provider,
+		Model:    config.Model,
+		Stream:   config.Stream,
+		Runs:     len(results),
+	}
+
+	var latencies []float64
+	var firstByte, tokensSec float64
+	for _, r := range results {
+		if r.Err != nil {
+			s.Failed++
+			continue
+		}
+		latencies = append(latencies, r.Latency.Seconds())
+		firstByte += r.FirstByte.Seconds()
+
+		// Throughput covers the generation only, not the wait for the first token
+		generation := (r.Latency - r.FirstByte).Seconds()
+		if generation > 0 {
+			tokensSec += float64(r.Tokens) / generation
+		}
+	}
+
+	ok := len(latencies)
+	if ok == 0 {
+		return s
+	}
+	sort.Float64s(latencies)
+	s.LatencyP50 = percentile(latencies, 0.50)
+	s.LatencyP95 = percentile(latencies, 0.95)
+	if config.Stream {
+		s.AvgFirstByte = firstByte / float64(ok)
+	}
+	s.AvgTokensSec = tokensSec / float64(ok)
+	return s
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// printBenchSummary prints the summary as an aligned table
+func printBenchSummary(s benchSummary) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "模型\t%s (%s)\n", s.Model, s.Provider)
+	fmt.Fprintf(w, "请求次数\t%d (失败 %d)\n", s.Runs, s.Failed)
+	fmt.Fprintf(w, "延迟 p50\t%.2f 秒\n", s.LatencyP50)
+	fmt.Fprintf(w, "延迟 p95\t%.2f 秒\n", s.LatencyP95)
+	if s.Stream {
+		fmt.Fprintf(w, "平均首字延迟\t%.2f 秒\n", s.AvgFirstByte)
+	}
+	fmt.Fprintf(w, "平均速度\t%.1f tok/s (估算)\n", s.AvgTokensSec)
+	w.Flush()
+}
+
+// estimateTokens roughly counts tokens: one per CJK character and one per
+// four other characters
+func estimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
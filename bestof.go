@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// judgePrompt instructs the judge model to answer with a candidate number only
+const judgePrompt = "你是一名严格的评审。下面给出一个用户问题和若干候选回答，请选出最准确、最简洁、最有帮助的一个。只输出该回答的编号（一个数字），不要输出其他任何内容。"
+
+// bestOfCandidate is one of the concurrently generated answers
+type bestOfCandidate struct {
+	Model  string
+	Answer string
+	Err    error
+}
+
+// bestOfAI asks the question n times concurrently and lets the judge model
+// pick the best answer. Candidates rotate through best_of_models and
+// best_of_keys when those are configured.
+func bestOfAI(question string, config *Config, n int) (string, error) {
+	candidates := make([]bestOfCandidate, n)
+	// Each candidate counts its own tokens, which are added up afterwards
+	usage := make([]streamStats, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		c := *config
+		c.usage = &usage[i]
+		c.Stream = false
+		c.Silent = true
+		c.Cache = false // Identical requests would otherwise share one answer
+		if len(config.BestOfModels) > 0 {
+			c.Model = config.BestOfModels[i%len(config.BestOfModels)]
+		}
+		if len(config.BestOfKeys) > 0 {
+			c.APIKey = config.BestOfKeys[i%len(config.BestOfKeys)]
+		}
+
+		wg.Add(1)
+		go func(i int, c Config) {
+			defer wg.Done()
+			answer, err := askAI(question, &c)
+			candidates[i] = bestOfCandidate{Model: c.Model, Answer: answer, Err: err}
+		}(i, c)
+	}
+	wg.Wait()
+	for _, stats := range usage {
+		config.addUsage(stats)
+	}
+
+	var valid []int
+	for i, cand := range candidates {
+		if cand.Err != nil {
+			if config.Verbose {
+				fmt.Fprintf(os.Stderr, "候选 %d (%s) 失败: %v\n", i+1, cand.Model, cand.Err)
+			}
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if len(valid) == 0 {
+		return "", candidates[0].Err
+	}
+	if len(valid) == 1 {
+		return candidates[valid[0]].Answer, nil
+	}
+
+	winner, err := judgeCandidates(question, candidates, valid, config)
+	if err != nil {
+		// A failed judgement should not throw away the answers
+		fmt.Fprintf(os.Stderr, "评审失败，使用第一个候选回答: %v\n", err)
+		winner = valid[0]
+	}
+	if config.Verbose {
+		fmt.Fprintf(os.Stderr, "best-of: 候选 %d (%s) 胜出，共 %d 个有效候选\n", winner+1, candidates[winner].Model, len(valid))
+	}
+
+	return candidates[winner].Answer, nil
+}
+
+// judgeNumber matches the candidate number in the judge's reply
+var judgeNumber = regexp.MustCompile(`\d+`)
+
+// judgeCandidates asks the judge model which of the valid candidates is best
+// and returns its index in candidates
+func judgeCandidates(question string, candidates []bestOfCandidate, valid []int, config *Config) (int, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "用户问题:\n%s\n", question)
+	for n, i := range valid {
+		fmt.Fprintf(&b, "\n候选回答 %d:\n%s\n", n+1, candidates[i].Answer)
+	}
+
+	c := *config
+	c.Stream = false
+	c.Silent = true
+	c.Cache = false
+	// Only the comparison is sent: no history, images or tools of the
+	// question, and no formatting instructions besides the judge prompt
+	c.Messages = nil
+	c.Images = nil
+	c.ToolsFile = ""
+	c.Logprobs = false
+	c.PromptTemplate = judgePrompt
+	c.Raw = true
+	if config.JudgeModel != "" {
+		c.Model = config.JudgeModel
+	}
+
+	var usage streamStats
+	c.usage = &usage
+	reply, err := askAI(b.String(), &c)
+	config.addUsage(usage)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(judgeNumber.FindString(reply))
+	if err != nil || n < 1 || n > len(valid) {
+		return 0, fmt.Errorf("无法识别评审结果: %q", reply)
+	}
+	return valid[n-1], nil
+}
+
+// splitList parses a comma-separated config value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestBestOf runs several candidates at once, as under go test -race, and
+// checks that the judge only gets the comparison and that the tokens of
+// every candidate and of the judge are counted
+func TestBestOf(t *testing.T) {
+	var requests int32
+	var judgeMessages atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		n := atomic.AddInt32(&requests, 1)
+
+		answer, in, out := fmt.Sprintf("candidate %d", n), 10, 5
+		var first Message
+		if len(body.Messages) > 0 && json.Unmarshal(body.Messages[0], &first) == nil && strings.Contains(first.Content, judgePrompt) {
+			answer, in, out = "2", 3, 1
+			judgeMessages.Store(body.Messages)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{
+				"message":       map[string]string{"role": "assistant", "content": answer},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": in, "completion_tokens": out},
+		})
+	}))
+	defer server.Close()
+
+	const n = 8
+	config := newConfig()
+	config.Provider = "openai"
+	config.APIURL = server.URL
+	config.APIKey = "sk-test"
+	config.Cache = false
+	config.Messages = []Message{{Role: "user", Content: "earlier question"}, {Role: "assistant", Content: "earlier answer"}}
+	config.Images = []string{"data:image/png;base64,iVBORw0KGgo="}
+	var usage streamStats
+	config.usage = &usage
+
+	answer, err := bestOfAI("question", config, n)
+	if err != nil {
+		t.Fatalf("bestOfAI: %v", err)
+	}
+	if !strings.HasPrefix(answer, "candidate ") {
+		t.Errorf("answer = %q, want one of the candidates", answer)
+	}
+	if got := atomic.LoadInt32(&requests); got != n+1 {
+		t.Errorf("%d requests, want %d candidates and the judge", got, n+1)
+	}
+	// The judge gets the comparison only, with its own prompt as the system message
+	messages, _ := judgeMessages.Load().([]json.RawMessage)
+	if len(messages) != 2 {
+		t.Fatalf("judge got %d messages, want the system prompt and the comparison", len(messages))
+	}
+	var system, comparison Message
+	if err := json.Unmarshal(messages[0], &system); err != nil || system.Content != judgePrompt {
+		t.Errorf("judge system prompt = %s, want exactly the judge prompt", messages[0])
+	}
+	if err := json.Unmarshal(messages[1], &comparison); err != nil || !strings.HasPrefix(comparison.Content, "用户问题:") {
+		t.Errorf("judge comparison = %s, want text content with the question and candidates", messages[1])
+	}
+	if usage.InputTokens != n*10+3 || usage.OutputTokens != n*5+1 {
+		t.Errorf("usage = %d in / %d out, want %d in / %d out", usage.InputTokens, usage.OutputTokens, n*10+3, n*5+1)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedResponse is the on-disk form of a cached answer
+type cachedResponse struct {
+	Answer  string    `json:"answer"`
+	Created time.Time `json:"created"`
+}
+
+// cacheDir returns the directory holding cached responses
+func cacheDir(config *Config) string {
+	if config.CacheDir != "" {
+		return config.CacheDir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "wen", "responses")
+}
+
+// cacheKey identifies a request by where it goes and exactly what is sent
+func cacheKey(config *Config, requestBody []byte) string {
+	h := sha256.New()
+	h.Write([]byte(config.Provider + "\n" + config.APIURL + "\n"))
+	h.Write(requestBody)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isDeterministic reports whether a request asks for reproducible output,
+// i.e. it sets temperature to 0 or fixes a seed. Requests relying on the
+// provider's default temperature are not deterministic.
+func isDeterministic(requestBody []byte) bool {
+	var body struct {
+		Temperature *float64    `json:"temperature"`
+		Seed        interface{} `json:"seed"`
+	}
+	if err := json.Unmarshal(requestBody, &body); err != nil {
+		return false
+	}
+	return body.Seed != nil || (body.Temperature != nil && *body.Temperature == 0)
+}
+
+// cacheable reports whether the request may be served from or stored in the cache
+func cacheable(config *Config, requestBody []byte) bool {
+	if !config.Cache {
+		return false
+	}
+	return !config.CacheOnlyDeterministic || isDeterministic(requestBody)
+}
+
+// cacheLookup returns the cached answer for a request, if there is one
+func cacheLookup(config *Config, requestBody []byte) (string, bool) {
+	if !cacheable(config, requestBody) {
+		return "", false
+	}
+
+	dir := cacheDir(config)
+	if _, err := os.Stat(dir); err != nil {
+		return "", false
+	}
+
+	var data []byte
+	err := withLock(config, cacheLockFile(dir), false, func() error {
+		var err error
+		data, err = os.ReadFile(filepath.Join(dir, cacheKey(config, requestBody)+".json"))
+		return err
+	})
+	if err != nil {
+		return "", false
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return "", false
+	}
+	return cached.Answer, true
+}
+
+// cacheStore saves an answer for later identical requests. Failures are
+// ignored; the cache is only an optimization.
+func cacheStore(config *Config, requestBody []byte, answer string) {
+	if !cacheable(config, requestBody) || answer == "" {
+		return
+	}
+
+	dir := cacheDir(config)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(cachedResponse{Answer: answer, Created: time.Now()})
+	if err != nil {
+		return
+	}
+
+	withLock(config, cacheLockFile(dir), true, func() error {
+		// Write to a temporary file first so readers never see a partial
+		// entry, even with locking turned off
+		tmp, err := os.CreateTemp(dir, "tmp-*")
+		if err != nil {
+			return err
+		}
+		_, werr := tmp.Write(data)
+		cerr := tmp.Close()
+		if werr != nil || cerr != nil {
+			os.Remove(tmp.Name())
+			return nil
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(dir, cacheKey(config, requestBody)+".json")); err != nil {
+			os.Remove(tmp.Name())
+		}
+		return nil
+	})
+}
+
+// cacheLockFile is the lock guarding all entries in a cache directory
+func cacheLockFile(dir string) string {
+	return filepath.Join(dir, ".lock")
+}
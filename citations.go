@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// defaultCitationPattern matches inline markers like [1], [1, 2] and [^3]
+	defaultCitationPattern = `[ \t]*\[\^?\d+(?:[,，\s]+\d+)*\]`
+	// referencesHeading matches the line starting a trailing references section
+	referencesHeading = regexp.MustCompile(`(?i)^\s*(?:#+\s*)?(?:\*\*)?(?:references|sources|citations|参考资料|参考文献|参考来源|来源|引用)(?:\*\*)?\s*[:：]?\s*(?:\*\*)?\s*$`)
+	// referenceEntry matches the lines of a references section: numbered or
+	// bulleted entries, or links
+	referenceEntry = regexp.MustCompile(`^\s*(?:\[\^?\d+\]|\d+[.)、]|[-*•]\s|\S*https?://)`)
+	// inlineCode matches a `code` span, whose brackets are not citations
+	inlineCode = regexp.MustCompile("`+[^`]+`+")
+)
+
+// compileCitationPatterns compiles the citation_patterns config values, or the
+// default pattern when there are none
+func compileCitationPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = []string{defaultCitationPattern}
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("citation_patterns 中的正则表达式 %q 无效: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// citationStripper removes inline citation markers and a trailing references
+// section from the answer, line by line. Code blocks and inline code are left
+// alone, as brackets there are index expressions like os.Args[1]. What it
+// removes can be saved to a file with --keep-citations-file: the references
+// section if there is one, otherwise the distinct markers, one per line.
+//
+// A references heading is only taken as such once it turns out to be
+// trailing: the heading and the entries after it are held back, and given
+// out again when a line follows that is not a reference.
+type citationStripper struct {
+	patterns []*regexp.Regexp
+	file     string
+	partial  string   // Incomplete last line of a stream
+	inCode   bool     // Inside a fenced code block
+	refs     []string // The held back heading and entries, if any
+	markers  []string
+	seen     map[string]bool
+}
+
+func newCitationStripper(config *Config) *citationStripper {
+	patterns, _ := compileCitationPatterns(config.CitationPatterns) // Checked when the config was loaded
+	return &citationStripper{patterns: patterns, file: config.KeepCitationsFile, seen: map[string]bool{}}
+}
+
+// Feed returns the cleaned text of all lines completed so far
+func (c *citationStripper) Feed(text string) string {
+	c.partial += text
+	end := strings.LastIndex(c.partial, "\n")
+	if end < 0 {
+		return ""
+	}
+	complete := c.partial[:end+1]
+	c.partial = c.partial[end+1:]
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(complete, "\n") {
+		if line != "" {
+			b.WriteString(c.line(line))
+		}
+	}
+	return b.String()
+}
+
+// Flush cleans the last, unterminated line and saves what was removed. A
+// references section still held back runs to the end of the answer, so it
+// is dropped.
+func (c *citationStripper) Flush() string {
+	line := c.partial
+	c.partial = ""
+	var text string
+	if line != "" {
+		text = c.line(line)
+	}
+	c.save()
+	return text
+}
+
+// line cleans one line, including its newline if it has one
+func (c *citationStripper) line(line string) string {
+	body := strings.TrimSuffix(line, "\n")
+	if len(c.refs) > 0 {
+		if strings.TrimSpace(body) == "" || referenceEntry.MatchString(body) {
+			c.refs = append(c.refs, line)
+			return ""
+		}
+		// Not a references section after all
+		held := c.refs
+		c.refs = nil
+		var b strings.Builder
+		b.WriteString(c.clean(held[0]))
+		for _, l := range held[1:] {
+			b.WriteString(c.line(l))
+		}
+		b.WriteString(c.line(line))
+		return b.String()
+	}
+	if !c.inCode && referencesHeading.MatchString(body) {
+		c.refs = []string{line}
+		return ""
+	}
+	return c.clean(line)
+}
+
+// clean removes the citation markers from a line outside code
+func (c *citationStripper) clean(line string) string {
+	body := strings.TrimSuffix(line, "\n")
+	newline := line[len(body):]
+	if trimmed := strings.TrimSpace(body); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+		c.inCode = !c.inCode
+		return line
+	}
+	if c.inCode {
+		return line
+	}
+
+	// Only the text between inline code spans is cleaned
+	var b strings.Builder
+	last := 0
+	for _, span := range inlineCode.FindAllStringIndex(body, -1) {
+		b.WriteString(c.removeMarkers(body[last:span[0]]))
+		b.WriteString(body[span[0]:span[1]])
+		last = span[1]
+	}
+	b.WriteString(c.removeMarkers(body[last:]))
+	return b.String() + newline
+}
+
+// removeMarkers removes and remembers the citation markers in text
+func (c *citationStripper) removeMarkers(text string) string {
+	for _, re := range c.patterns {
+		text = re.ReplaceAllStringFunc(text, func(marker string) string {
+			marker = strings.TrimSpace(marker)
+			if !c.seen[marker] {
+				c.seen[marker] = true
+				c.markers = append(c.markers, marker)
+			}
+			return ""
+		})
+	}
+	return text
+}
+
+// save writes the removed citations to the --keep-citations-file
+func (c *citationStripper) save() {
+	if c.file == "" {
+		return
+	}
+	content := strings.TrimSpace(strings.Join(c.refs, ""))
+	if content == "" {
+		content = strings.Join(c.markers, "\n")
+	}
+	if content == "" {
+		return
+	}
+	if err := os.WriteFile(c.file, []byte(content+"\n"), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "保存引用失败: %v\n", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCitationStripper(t *testing.T) {
+	tests := []struct {
+		name, answer, want, kept string
+	}{
+		{
+			"markers",
+			"Go is fast [1] and simple [2, 3].\n",
+			"Go is fast and simple.\n",
+			"[1]\n[2, 3]",
+		},
+		{
+			"inline code",
+			"Read `os.Args[1]` first [1].\n",
+			"Read `os.Args[1]` first.\n",
+			"[1]",
+		},
+		{
+			"fenced code",
+			"Example [1]:\n```go\nname := os.Args[1]\n```\nDone.\n",
+			"Example:\n```go\nname := os.Args[1]\n```\nDone.\n",
+			"[1]",
+		},
+		{
+			"trailing references",
+			"Go is fast [1].\n\n## References\n[1] https://go.dev\n2. The Go Blog\n",
+			"Go is fast.\n\n",
+			"## References\n[1] https://go.dev\n2. The Go Blog",
+		},
+		{
+			"heading mid-answer",
+			"Answer [1].\n来源\n- the manual\nThe rest of the answer [2].\n",
+			"Answer.\n来源\n- the manual\nThe rest of the answer.\n",
+			"[1]\n[2]",
+		},
+		{
+			"heading in code",
+			"```\nSources\n[1] a\n```\nafter\n",
+			"```\nSources\n[1] a\n```\nafter\n",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newConfig()
+			config.KeepCitationsFile = filepath.Join(t.TempDir(), "citations.txt")
+			c := newCitationStripper(config)
+
+			// Fed in small pieces, as a stream arrives
+			var got strings.Builder
+			for i := 0; i < len(tt.answer); i += 5 {
+				end := i + 5
+				if end > len(tt.answer) {
+					end = len(tt.answer)
+				}
+				got.WriteString(c.Feed(tt.answer[i:end]))
+			}
+			got.WriteString(c.Flush())
+			if got.String() != tt.want {
+				t.Errorf("cleaned = %q, want %q", got.String(), tt.want)
+			}
+
+			kept, err := os.ReadFile(config.KeepCitationsFile)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(kept)); got != tt.kept {
+				t.Errorf("kept = %q, want %q", got, tt.kept)
+			}
+		})
+	}
+}
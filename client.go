@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// apiClient sends the requests to the providers. It is shared so that
+// connections are kept alive between requests, e.g. in interactive mode.
+// Proxies are taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+var apiClient = &http.Client{Transport: newTransport(http.ProxyFromEnvironment)}
+
+var (
+	proxyClientsMu sync.Mutex
+	proxyClients   = map[string]*http.Client{} // By proxy URL, see the proxy config
+)
+
+// newTransport returns a copy of the default transport that uses proxy
+func newTransport(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	return t
+}
+
+// httpClient returns the client for requests made with config: apiClient, or
+// one going through the configured proxy
+func httpClient(config *Config) *http.Client {
+	if config.Proxy == "" {
+		return apiClient
+	}
+	proxyClientsMu.Lock()
+	defer proxyClientsMu.Unlock()
+	client, ok := proxyClients[config.Proxy]
+	if !ok {
+		proxyURL, _ := url.Parse(config.Proxy) // Checked by parseProxy
+		client = &http.Client{Transport: newTransport(http.ProxyURL(proxyURL))}
+		proxyClients[config.Proxy] = client
+	}
+	return client
+}
+
+// parseProxy checks the value of the proxy config, a proxy URL such as
+// http://proxy.example.com:8080
+func parseProxy(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("proxy 不是有效的代理地址: %q", value)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	}
+	return fmt.Errorf("proxy 的协议 %s 不受支持，应为 http、https 或 socks5", u.Scheme)
+}
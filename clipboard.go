@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommand returns the command that copies its standard input to
+// the system clipboard: pbcopy on macOS, clip on Windows, and wl-copy,
+// xclip or xsel elsewhere, whichever is installed
+func clipboardCommand() ([]string, error) {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		candidates = append(candidates,
+			[]string{"xclip", "-selection", "clipboard"},
+			[]string{"xsel", "--clipboard", "--input"},
+		)
+	}
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err == nil {
+			return c, nil
+		}
+	}
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c[0]
+	}
+	return nil, fmt.Errorf("无法复制到剪贴板: 没有找到 %s", strings.Join(names, "、"))
+}
+
+// copyToClipboard puts text on the system clipboard
+func copyToClipboard(text string) error {
+	command, err := clipboardCommand()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("无法复制到剪贴板: %s: %v %s", command[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"sync"
+)
+
+var (
+	colorOnce sync.Once
+	colorOn   bool
+)
+
+// ansiPattern matches ANSI escape sequences such as "\033[1;31m"
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// colorEnabled reports whether escape sequences may be written to stdout.
+// Colors are disabled when NO_COLOR is set, when stdout is not a terminal,
+// and when TERM is unset or "dumb". The result is computed once.
+func colorEnabled() bool {
+	colorOnce.Do(func() {
+		colorOn = detectColor(os.Stdout)
+	})
+	return colorOn
+}
+
+// detectColor makes the decision of colorEnabled for output written to out
+func detectColor(out *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+	return isTerminal(out)
+}
+
+// isTerminal reports whether f is connected to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// styleText wraps text in an ANSI style, or returns it unchanged when colors
+// are disabled
+func styleText(style, text string) string {
+	if !colorEnabled() {
+		return text
+	}
+	return style + text + resetStyle
+}
+
+// stripANSI removes all ANSI escape sequences from text
+func stripANSI(text string) string {
+	return ansiPattern.ReplaceAllString(text, "")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDetectColor(t *testing.T) {
+	// isTerminal accepts any character device, so /dev/null stands in for a
+	// terminal; a pipe is what stdout is when the output is redirected
+	terminal, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("no %s: %v", os.DevNull, err)
+	}
+	defer terminal.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	unset := "<unset>"
+	tests := []struct {
+		name    string
+		noColor string
+		term    string
+		out     *os.File
+		want    bool
+	}{
+		{"terminal", unset, "xterm-256color", terminal, true},
+		{"NO_COLOR", "1", "xterm-256color", terminal, false},
+		{"empty NO_COLOR", "", "xterm-256color", terminal, true},
+		{"TERM=dumb", unset, "dumb", terminal, false},
+		{"TERM unset", unset, unset, terminal, false},
+		{"TERM empty", unset, "", terminal, false},
+		{"not a terminal", unset, "xterm-256color", w, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range map[string]string{"NO_COLOR": tt.noColor, "TERM": tt.term} {
+				t.Setenv(name, value) // Restored after the test
+				if value == unset {
+					os.Unsetenv(name)
+				}
+			}
+			if got := detectColor(tt.out); got != tt.want {
+				t.Errorf("detectColor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// modelPrice is the price of a model in USD per million tokens
+type modelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// modelPrices are the list prices of common models, matched by the longest
+// prefix of the model name so that dated versions such as
+// claude-3-5-sonnet-20241022 are found too
+var modelPrices = map[string]modelPrice{
+	"gpt-3.5-turbo":     {0.50, 1.50},
+	"gpt-4":             {30, 60},
+	"gpt-4-turbo":       {10, 30},
+	"gpt-4o":            {2.50, 10},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4.1":           {2, 8},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"o1":                {15, 60},
+	"o1-mini":           {1.10, 4.40},
+	"o3-mini":           {1.10, 4.40},
+	"claude-3-haiku":    {0.25, 1.25},
+	"claude-3-opus":     {15, 75},
+	"claude-3-5-haiku":  {0.80, 4},
+	"claude-3-5-sonnet": {3, 15},
+	"claude-3-7-sonnet": {3, 15},
+	"gemini-1.5-flash":  {0.075, 0.30},
+	"gemini-1.5-pro":    {1.25, 5},
+	"gemini-2.0-flash":  {0.10, 0.40},
+	"deepseek-chat":     {0.27, 1.10},
+	"deepseek-reasoner": {0.55, 2.19},
+}
+
+// lookupPrice returns the price of model: from price_override, else from
+// modelPrices, using the entry with the longest matching prefix
+func lookupPrice(model string, config *Config) (modelPrice, bool) {
+	for _, table := range []map[string]modelPrice{config.PriceOverride, modelPrices} {
+		best := ""
+		for prefix := range table {
+			if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best != "" {
+			return table[best], true
+		}
+	}
+	return modelPrice{}, false
+}
+
+// parsePriceOverride adds the comma-separated model:input/output entries of a
+// price_override value to config.PriceOverride, with prices in USD per
+// million tokens
+func parsePriceOverride(value string, config *Config) error {
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		model, prices, found := strings.Cut(entry, ":")
+		in, out, found2 := strings.Cut(prices, "/")
+		input, err := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		output, err2 := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		model = strings.TrimSpace(model)
+		if !found || !found2 || model == "" || err != nil || err2 != nil || input < 0 || output < 0 {
+			return fmt.Errorf("price_override 中的 %q 格式错误，应为 模型:输入价格/输出价格", entry)
+		}
+		if config.PriceOverride == nil {
+			config.PriceOverride = map[string]modelPrice{}
+		}
+		config.PriceOverride[model] = modelPrice{input, output}
+	}
+	return nil
+}
+
+// formatCost describes the estimated cost of the tokens in stats for the
+// timing line, e.g. "约 $0.0046", or returns "" for models without a price
+func formatCost(stats streamStats, config *Config) string {
+	price, ok := lookupPrice(config.Model, config)
+	if !ok || stats.InputTokens == 0 && stats.OutputTokens == 0 {
+		return ""
+	}
+	cost := (float64(stats.InputTokens)*price.Input + float64(stats.OutputTokens)*price.Output) / 1e6
+	if cost > 0 && cost < 0.0001 {
+		return "约 <$0.0001"
+	}
+	return fmt.Sprintf("约 $%.4f", cost)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// credentialHeaders are the headers carrying the API key, or a token made
+// from it, for the providers wen supports
+var credentialHeaders = map[string]bool{
+	"Authorization":  true,
+	"X-Api-Key":      true,
+	"Api-Key":        true,
+	"X-Goog-Api-Key": true,
+}
+
+// splitCredential splits the value of a credential header into its scheme,
+// such as "Bearer ", and the secret itself
+func splitCredential(value string) (scheme, secret string) {
+	if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+		return "Bearer ", token
+	}
+	return "", value
+}
+
+// curlCommand renders a request as an equivalent curl command line. The
+// credential headers, and anything else containing the API key, show
+// $API_KEY instead, so the command can be shared and still run after
+// "export API_KEY=...". Tokens signed with the key, as for zhipu, are masked
+// the same way and need the signed token in $API_KEY.
+func curlCommand(req *http.Request, requestBody []byte, apiKey string) string {
+	var b strings.Builder
+	b.WriteString("curl")
+	if req.Header.Get("Accept") == "text/event-stream" {
+		b.WriteString(" -N") // Show the stream as it arrives
+	}
+	fmt.Fprintf(&b, " -X %s %s", req.Method, shellQuote(req.URL.String()))
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if credentialHeaders[name] {
+				scheme, _ := splitCredential(value)
+				fmt.Fprintf(&b, " \\\n  -H \"%s: %s$API_KEY\"", name, scheme)
+				continue
+			}
+			header := name + ": " + value
+			if apiKey != "" && strings.Contains(header, apiKey) {
+				// Double quotes so the shell expands the variable
+				parts := strings.Split(header, apiKey)
+				for i, part := range parts {
+					parts[i] = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(part)
+				}
+				fmt.Fprintf(&b, " \\\n  -H \"%s\"", strings.Join(parts, "$API_KEY"))
+			} else {
+				fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(header))
+			}
+		}
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, requestBody, "", "  "); err != nil {
+		pretty.Reset()
+		pretty.Write(requestBody)
+	}
+	fmt.Fprintf(&b, " \\\n  --data %s", shellQuote(pretty.String()))
+	return b.String()
+}
+
+// dryRunRequest describes a request for --dry-run: the method and URL, the
+// headers with the API key masked, and the pretty-printed body
+func dryRunRequest(req *http.Request, requestBody []byte, apiKey string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", req.Method, req.URL)
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			// The bearer token may be derived from the key, as for zhipu
+			if credentialHeaders[name] {
+				scheme, secret := splitCredential(value)
+				value = scheme + maskKey(secret)
+			} else if apiKey != "" {
+				value = strings.ReplaceAll(value, apiKey, maskKey(apiKey))
+			}
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, requestBody, "", "  "); err != nil {
+		pretty.Reset()
+		pretty.Write(requestBody)
+	}
+	fmt.Fprintf(&b, "\n%s\n", pretty.String())
+	return b.String()
+}
+
+// maskKey hides all but the ends of an API key, e.g. "sk-p...x9Qa"
+func maskKey(key string) string {
+	if len(key) < 12 {
+		return "****"
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCurlCommandHidesCredentials(t *testing.T) {
+	tests := []struct {
+		provider string
+		apiKey   string
+		header   string // The credential header the command should show
+	}{
+		{"openai", "sk-test-0123456789", `-H "Authorization: Bearer $API_KEY"`},
+		{"anthropic", "sk-ant-0123456789", `-H "X-Api-Key: $API_KEY"`},
+		{"azure", "azure-0123456789", `-H "Api-Key: $API_KEY"`},
+		{"gemini", "AIza-0123456789", `-H "X-Goog-Api-Key: $API_KEY"`},
+		// The bearer token is a JWT signed with the key, not the key itself
+		{"zhipu", "0123456789.abcdefghij", `-H "Authorization: Bearer $API_KEY"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			config := newConfig()
+			config.Provider = tt.provider
+			config.APIKey = tt.apiKey
+			config.Model = "test-model"
+			config.APIURL = "https://api.example.com/v1"
+			req, body, err := buildRequest("hi", config, false)
+			if err != nil {
+				t.Fatalf("buildRequest: %v", err)
+			}
+
+			command := curlCommand(req, body, config.APIKey)
+			if !strings.Contains(command, tt.header) {
+				t.Errorf("command does not contain %s:\n%s", tt.header, command)
+			}
+			_, secret := splitCredential(req.Header.Get("Authorization"))
+			for _, leaked := range []string{tt.apiKey, secret} {
+				if leaked != "" && strings.Contains(command, leaked) {
+					t.Errorf("command contains the credential %q:\n%s", leaked, command)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// debugEnabled reports whether the request debug output is on, with
+// debug=true in the config or WEN_DEBUG=1 in the environment
+func (config *Config) debugEnabled() bool {
+	return (config.Debug || parseBool(os.Getenv("WEN_DEBUG"))) && !config.Silent
+}
+
+// printRequestDebug shows on stderr what is about to be sent to the provider,
+// so stdout carries nothing but the answer
+func printRequestDebug(config *Config, provider, systemPrompt, question string) {
+	if !config.debugEnabled() {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "\n"+styleText("\033[1m", "发送给 "+provider+" 的内容:"))
+	fmt.Fprintf(os.Stderr, "系统提示: %s\n", systemPrompt)
+	if len(config.Messages) > 0 {
+		fmt.Fprintf(os.Stderr, "预设消息: %d 条\n", len(config.Messages))
+	}
+	fmt.Fprintf(os.Stderr, "用户问题: %s\n", question)
+	if len(config.Images) > 0 {
+		fmt.Fprintf(os.Stderr, "图片: %d 张 (精度 %s)\n", len(config.Images), config.ImageDetail)
+	}
+	fmt.Fprintln(os.Stderr)
+}
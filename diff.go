@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxDiffCells bounds the LCS table; larger inputs are shown as a full replacement
+const maxDiffCells = 4 << 20
+
+// refinePrompt asks the model to improve a previous answer
+const refinePrompt = "请改进下面对用户问题的回答，使其更准确、更简洁，修正其中的错误。只输出改进后的完整回答。"
+
+// diffOp is one token of a word diff
+type diffOp struct {
+	Kind byte // '=', '-' or '+'
+	Text string
+}
+
+// diffTokens splits text into words, whitespace runs and punctuation. CJK
+// characters are separate tokens since those languages don't use spaces.
+func diffTokens(text string) []string {
+	var tokens []string
+	var cur []rune
+	var curKind int // 0 none, 1 word, 2 space
+
+	flush := func() {
+		if len(cur) > 0 {
+			tokens = append(tokens, string(cur))
+			cur = cur[:0]
+		}
+		curKind = 0
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if curKind != 1 {
+				flush()
+				curKind = 1
+			}
+			cur = append(cur, r)
+		case unicode.IsSpace(r):
+			if curKind != 2 {
+				flush()
+				curKind = 2
+			}
+			cur = append(cur, r)
+		default:
+			flush()
+			tokens = append(tokens, string(r))
+		}
+	}
+	flush()
+	return tokens
+}
+
+// wordDiff computes the changes from old to new with a longest common
+// subsequence over their tokens
+func wordDiff(old, new string) []diffOp {
+	a, b := diffTokens(old), diffTokens(new)
+	if len(a)*len(b) > maxDiffCells {
+		return []diffOp{{'-', old}, {'+', new}}
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	add := func(kind byte, text string) {
+		if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, diffOp{kind, text})
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add('=', a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add('-', a[i])
+			i++
+		default:
+			add('+', b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add('-', a[i])
+	}
+	for ; j < len(b); j++ {
+		add('+', b[j])
+	}
+	return ops
+}
+
+// renderWordDiff shows the diff with deletions in red and additions in green.
+// Without color support git's word-diff markers [-...-] and {+...+} are used.
+func renderWordDiff(old, new string) string {
+	var b strings.Builder
+	for _, op := range wordDiff(plainText(old), plainText(new)) {
+		switch op.Kind {
+		case '-':
+			if colorEnabled() {
+				b.WriteString("\033[31;9m" + op.Text + resetStyle)
+			} else {
+				b.WriteString("[-" + op.Text + "-]")
+			}
+		case '+':
+			if colorEnabled() {
+				b.WriteString("\033[32m" + op.Text + resetStyle)
+			} else {
+				b.WriteString("{+" + op.Text + "+}")
+			}
+		default:
+			b.WriteString(op.Text)
+		}
+	}
+	return b.String()
+}
+
+// plainText removes formatting tags and escape sequences from an answer
+func plainText(text string) string {
+	return stripANSI(processTerminalFormatting(text))
+}
+
+// compareAI asks the question with the configured model and with other
+// concurrently, prints the first answer and then the diff to the second one.
+// It returns the second answer.
+func compareAI(question string, config *Config, other string) (string, error) {
+	first, second := *config, *config
+	second.Model = other
+	// The requests run at once, so each counts its own tokens
+	var usage [2]streamStats
+	first.usage, second.usage = &usage[0], &usage[1]
+
+	var answers [2]string
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, c := range []*Config{&first, &second} {
+		wg.Add(1)
+		go func(i int, c *Config) {
+			defer wg.Done()
+			answers[i], errs[i] = askAI(question, c)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, stats := range usage {
+		config.addUsage(stats)
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", []string{config.Model, other}[i], err)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, styleText("\033[1m", "["+config.Model+"]"))
+	fmt.Println(processTerminalFormatting(answers[0]))
+	fmt.Fprintln(os.Stderr, "\n"+styleText("\033[1m", "["+config.Model+" → "+other+"]"))
+	fmt.Println(renderWordDiff(answers[0], answers[1]))
+	return answers[1], nil
+}
+
+// refineAI asks the question, then asks the model to improve its answer and
+// prints the diff between the two versions. It returns the refined answer.
+func refineAI(question string, config *Config) (string, error) {
+	first := *config
+	answer, err := askAI(question, &first)
+	if err != nil {
+		return "", err
+	}
+
+	second := *config
+	followUp := fmt.Sprintf("%s\n\n问题:\n%s\n\n回答:\n%s", refinePrompt, question, answer)
+	refined, err := askAI(followUp, &second)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println(renderWordDiff(answer, refined))
+	return refined, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envRefPattern matches a ${NAME} reference to an environment variable
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvRefs replaces the ${NAME} references in value with the values of
+// the environment variables, and returns the names of those that are unset
+func expandEnvRefs(value string) (string, []string) {
+	var unset []string
+	expanded := envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRefPattern.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			unset = append(unset, name)
+		}
+		return v
+	})
+	return expanded, unset
+}
+
+// readEnvFile parses a .env file of KEY=VALUE lines. Comments, blank lines
+// and an "export " prefix are allowed, and values may be quoted.
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+// applyEnvFile sets the API key from the active provider's standard variable,
+// e.g. OPENAI_API_KEY, in a .env file. An explicitly given file must exist;
+// the default ./.env is optional.
+func applyEnvFile(config *Config, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = ".env"
+	}
+
+	vars, err := readEnvFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("读取 .env 文件失败: %w", err)
+	}
+
+	if p, ok := lookupProvider(config.Provider); ok && p.KeyEnv != "" {
+		config.APIKey = vars[p.KeyEnv]
+	}
+	return nil
+}
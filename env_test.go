@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadEnvFileBOMAndCRLF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "\ufeffOPENAI_API_KEY=sk-test\r\n# comment\r\n\r\nexport ANTHROPIC_API_KEY=\"sk-ant\"\r\nMODEL='gpt-test'\r\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := readEnvFile(path)
+	if err != nil {
+		t.Fatalf("readEnvFile: %v", err)
+	}
+	want := map[string]string{
+		"OPENAI_API_KEY":    "sk-test",
+		"ANTHROPIC_API_KEY": "sk-ant",
+		"MODEL":             "gpt-test",
+	}
+	for key, value := range want {
+		if vars[key] != value {
+			t.Errorf("%s = %q, want %q", key, vars[key], value)
+		}
+	}
+	if len(vars) != len(want) {
+		t.Errorf("vars = %q, want %q", vars, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Exit codes, so that scripts can tell why a question failed
+const (
+	exitConfig   = 1 // Options, config or input are wrong, and anything else
+	exitNetwork  = 2 // The API could not be reached, or the connection broke
+	exitAPI      = 3 // The API answered with an error, e.g. for a bad key
+	exitResponse = 4 // The response could not be understood or was empty
+)
+
+// exitError gives an error the exit code it ends wen with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// networkFailure marks err as a failure to reach the API or read from it
+func networkFailure(err error) error {
+	return &exitError{code: exitNetwork, err: err}
+}
+
+// apiFailure marks err as an error the API reported inside a response,
+// such as an error event in a stream
+func apiFailure(err error) error {
+	return &exitError{code: exitAPI, err: err}
+}
+
+// responseFailure marks err as a response that could not be used
+func responseFailure(err error) error {
+	return &exitError{code: exitResponse, err: err}
+}
+
+// exitCode returns the exit code for a failed question. Errors that are not
+// marked are taken to be config problems.
+func exitCode(err error) int {
+	var apiErr *apiError
+	var exitErr *exitError
+	switch {
+	case errors.As(err, &apiErr):
+		return exitAPI
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	}
+	return exitConfig
+}
+
+// apiError is returned when the API answers with a non-200 status
+type apiError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *apiError) Error() string {
+	return parseAPIError(e.StatusCode, e.Body)
+}
+
+// parseAPIError describes an error response by its HTTP status and the
+// message in the body. OpenAI, Anthropic and Gemini send
+// {"error": {"message": ...}}, Ollama {"error": "..."}; other bodies are
+// shown as they are.
+func parseAPIError(status int, body []byte) string {
+	var response struct {
+		Error json.RawMessage `json:"error"`
+	}
+	message := ""
+	if json.Unmarshal(body, &response) == nil && len(response.Error) > 0 {
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(response.Error, &detail) == nil {
+			message = detail.Message
+		} else {
+			json.Unmarshal(response.Error, &message)
+		}
+	}
+	if message == "" {
+		message = strings.TrimSpace(string(body))
+	}
+	return fmt.Sprintf("API返回错误 (HTTP %d): %s", status, message)
+}
+
+// contextLengthCodes are the error codes and types providers use when the
+// prompt does not fit the model's context window
+var contextLengthCodes = []string{
+	"context_length_exceeded",
+}
+
+// contextLengthMarkers are the messages of such errors from providers that
+// send no specific code, such as Anthropic's invalid_request_error
+var contextLengthMarkers = []string{
+	"maximum context length",
+	"context window",
+	"prompt is too long",
+	"too many tokens",
+	"input is too long",
+}
+
+// isContextLengthError reports whether err says the prompt was too long. It
+// looks at the code and type of the error first and then at its message, so
+// that rate limits (429) and errors merely quoting such text do not count.
+func isContextLengthError(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 ||
+		apiErr.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+
+	var response struct {
+		Error json.RawMessage `json:"error"`
+	}
+	var detail struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Code    json.RawMessage `json:"code"` // A number for some providers
+	}
+	message := string(apiErr.Body)
+	if json.Unmarshal(apiErr.Body, &response) == nil && len(response.Error) > 0 {
+		if json.Unmarshal(response.Error, &detail) == nil {
+			var code string
+			json.Unmarshal(detail.Code, &code)
+			for _, c := range contextLengthCodes {
+				if code == c || detail.Type == c {
+					return true
+				}
+			}
+			message = detail.Message
+		} else {
+			json.Unmarshal(response.Error, &message)
+		}
+	}
+	message = strings.ToLower(message)
+	for _, marker := range contextLengthMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptInfo records how many requests an answer took and the HTTP status
+// of the last one, for monitoring flaky providers
+type attemptInfo struct {
+	Attempts   int `json:"attempts"`
+	LastStatus int `json:"last_status,omitempty"` // Zero when no response was received
+}
+
+// count records a request that was answered with status, or zero when it
+// got no response. It does nothing on a nil attemptInfo.
+func (a *attemptInfo) count(status int) {
+	if a == nil {
+		return
+	}
+	a.Attempts++
+	a.LastStatus = status
+}
+
+// askWithFallback runs ask and, when the prompt exceeds the model's context
+// window, retries once with fallback_large_context_model. The fallback is off
+// unless that model is configured, since a larger model usually costs more.
+func askWithFallback(question string, config *Config, ask func(string, *Config) (string, error)) (string, attemptInfo, error) {
+	var info attemptInfo
+	c := *config
+	c.attempts = &info
+	answer, err := ask(question, &c)
+	if err == nil || config.FallbackModel == "" || config.FallbackModel == config.Model || !isContextLengthError(err) {
+		return answer, info, err
+	}
+
+	notice("提示超出 %s 的上下文长度，改用 %s 重试\n", config.Model, config.FallbackModel)
+	c = *config
+	c.Model = config.FallbackModel
+	c.attempts = &info
+	answer, err = ask(question, &c)
+	return answer, info, err
+}
+
+// reportAttempts prints the attempt count on stderr with --count-retries, or
+// with --verbose when more than one request was needed
+func reportAttempts(config *Config, info attemptInfo) {
+	if info.Attempts == 0 || !(config.CountRetries || config.Verbose && info.Attempts > 1) {
+		return
+	}
+	status := "无响应"
+	if info.LastStatus != 0 {
+		status = fmt.Sprint(info.LastStatus)
+	}
+	fmt.Fprintf(os.Stderr, "请求次数: %d (最后状态码 %s)\n", info.Attempts, status)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIsContextLengthError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"OpenAI code", 400, `{"error":{"message":"This model's maximum context length is 8192 tokens","type":"invalid_request_error","code":"context_length_exceeded"}}`, true},
+		{"Anthropic message", 400, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, true},
+		{"Ollama string", 400, `{"error":"input is too long for the context window"}`, true},
+		{"plain text", 413, `Input is too long`, true},
+		{"rate limit", 429, `{"error":{"message":"Rate limit reached: too many tokens per minute","type":"tokens","code":"rate_limit_exceeded"}}`, false},
+		{"other error quoting a marker", 400, `{"error":{"message":"Invalid value for 'stop'","type":"invalid_request_error","code":"invalid_value","param":"prompt is too long"}}`, false},
+		{"server error", 500, `{"error":{"message":"maximum context length","code":"context_length_exceeded"}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := apiFailure(fmt.Errorf("请求AI失败: %w", &apiError{StatusCode: tt.status, Body: []byte(tt.body)}))
+			if got := isContextLengthError(err); got != tt.want {
+				t.Errorf("isContextLengthError = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
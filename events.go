@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// streamEvent is one line of --events-ndjson output. Type is one of
+// "content", "reasoning", "tool_call", "finish", "usage" and "done".
+type streamEvent struct {
+	Type         string          `json:"type"`
+	Delta        string          `json:"delta,omitempty"`
+	Name         string          `json:"name,omitempty"`  // Function name of a tool_call
+	Index        *int            `json:"index,omitempty"` // Tool call index of a tool_call
+	FinishReason string          `json:"finish_reason,omitempty"`
+	Usage        json.RawMessage `json:"usage,omitempty"`
+}
+
+// writeEvent prints an event as a single JSON line on stdout
+func writeEvent(event streamEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// writeUsageEvent emits a usage event if the raw usage object is present
+func writeUsageEvent(usage json.RawMessage) {
+	if len(usage) == 0 || string(usage) == "null" {
+		return
+	}
+	writeEvent(streamEvent{Type: "usage", Usage: usage})
+}
+
+// rawStream reports whether the stream is forwarded in a machine-readable
+// form instead of being rendered for the terminal
+func (config *Config) rawStream() bool {
+	return config.ProxyStream || config.EventsNDJSON
+}
@@ -0,0 +1,61 @@
+package main
+
+import "strings"
+
+// streamFilter transforms answer text as it streams in. Feed returns the text
+// that can be shown now; Flush returns whatever was held back, at the end.
+type streamFilter interface {
+	Feed(text string) string
+	Flush() string
+}
+
+// answerFilters returns the configured answer filters in the order they
+// apply, see pipelineSteps
+func answerFilters(config *Config) []streamFilter {
+	var filters []streamFilter
+	for _, step := range pipelineSteps(config) {
+		if f := step.filter(config); f != nil {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+// stopper is a filter that can end the answer early
+type stopper interface {
+	Stopped() bool
+}
+
+// filtersStopped reports whether one of the filters has ended the answer
+func filtersStopped(filters []streamFilter) bool {
+	for _, f := range filters {
+		if s, ok := f.(stopper); ok && s.Stopped() {
+			return true
+		}
+	}
+	return false
+}
+
+// feedFilters passes text through each filter in turn
+func feedFilters(filters []streamFilter, text string) string {
+	for _, f := range filters {
+		text = f.Feed(text)
+	}
+	return text
+}
+
+// flushFilters flushes the filters in order, passing what each one held back
+// through the filters after it
+func flushFilters(filters []streamFilter) string {
+	var b strings.Builder
+	for i, f := range filters {
+		b.WriteString(feedFilters(filters[i+1:], f.Flush()))
+	}
+	return b.String()
+}
+
+// filterAnswer applies the configured filters to a complete answer
+func filterAnswer(answer string, config *Config) string {
+	filters := answerFilters(config)
+	return feedFilters(filters, answer) + flushFilters(filters)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// geminiPart is a piece of Gemini message content. Parts with Thought set
+// carry the model's reasoning.
+type geminiPart struct {
+	Text    string `json:"text"`
+	Thought bool   `json:"thought,omitempty"`
+}
+
+// geminiContent is a Gemini message
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiResponse is a generateContent response, and each event of a
+// streamGenerateContent stream
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// text returns the reasoning and answer text of the first candidate
+func (r *geminiResponse) text() (thought, text string) {
+	if len(r.Candidates) == 0 {
+		return "", ""
+	}
+	for _, part := range r.Candidates[0].Content.Parts {
+		if part.Thought {
+			thought += part.Text
+		} else {
+			text += part.Text
+		}
+	}
+	return thought, text
+}
+
+// stats returns the token usage and finish reason of the response
+func (r *geminiResponse) stats() streamStats {
+	stats := streamStats{
+		InputTokens:  r.UsageMetadata.PromptTokenCount,
+		OutputTokens: r.UsageMetadata.CandidatesTokenCount,
+	}
+	if len(r.Candidates) > 0 {
+		stats.StopReason = r.Candidates[0].FinishReason
+	}
+	return stats
+}
+
+// geminiURL returns the endpoint for the configured model. api_url is the
+// models collection, e.g. https://generativelanguage.googleapis.com/v1beta/models;
+// streams are requested as SSE.
+func geminiURL(config *Config, stream bool) string {
+	base := strings.TrimSuffix(config.APIURL, "/")
+	if stream {
+		return base + "/" + config.Model + ":streamGenerateContent?alt=sse"
+	}
+	return base + "/" + config.Model + ":generateContent"
+}
+
+// createGeminiRequest creates the request body for the Gemini API. The model
+// is part of the URL, and Gemini calls the assistant role "model".
+func createGeminiRequest(question, systemPrompt string, config *Config, stream bool) ([]byte, error) {
+	if len(config.Images) > 0 {
+		return nil, fmt.Errorf("--image 目前只支持 OpenAI 和 Anthropic")
+	}
+
+	var contents []geminiContent
+	for _, m := range conversation(question, config) {
+		role := m.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: []geminiPart{{Text: m.Content}}})
+	}
+	requestBody := map[string]interface{}{
+		"contents": contents,
+	}
+	if systemPrompt != "" {
+		requestBody["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: systemPrompt}}}
+	}
+	generation := map[string]interface{}{}
+	if config.Temperature != nil {
+		generation["temperature"] = *config.Temperature
+	}
+	if config.MaxTokens > 0 {
+		generation["maxOutputTokens"] = config.MaxTokens
+	}
+	if len(generation) > 0 {
+		requestBody["generationConfig"] = generation
+	}
+	if len(config.SafetySettings) > 0 {
+		requestBody["safetySettings"] = config.SafetySettings
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	printRequestDebug(config, "Gemini", systemPrompt, question)
+
+	return jsonData, nil
+}
+
+// parseGeminiResponse parses the response from the Gemini API
+func parseGeminiResponse(responseBody []byte) (string, error) {
+	var response geminiResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", responseFailure(fmt.Errorf("解析响应失败: %w", err))
+	}
+	if reason := response.PromptFeedback.BlockReason; reason != "" {
+		return "", apiFailure(fmt.Errorf("问题被 Gemini 拦截: %s", reason))
+	}
+	if len(response.Candidates) == 0 {
+		return "", responseFailure(fmt.Errorf("API返回了空的响应"))
+	}
+
+	thought, text := response.text()
+	if text == "" && thought == "" && response.Candidates[0].FinishReason == "SAFETY" {
+		return "", apiFailure(fmt.Errorf("回答被 Gemini 拦截: SAFETY"))
+	}
+	return wrapReasoning(thought, text), nil
+}
+
+// geminiFinishReason maps a Gemini finish reason to the OpenAI one
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// processGeminiStream processes the SSE response of streamGenerateContent.
+// Every event is a complete response object holding the next text parts.
+func processGeminiStream(responseBody io.Reader, config *Config) (string, streamStats, error) {
+	scanner := newStreamScanner(responseBody)
+	var fullResponse string
+	var stats streamStats
+	rs := newReasoningStream(config)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+
+		var event geminiResponse
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue // Skip malformed data
+		}
+		if reason := event.PromptFeedback.BlockReason; reason != "" {
+			return fullResponse, stats, apiFailure(fmt.Errorf("问题被 Gemini 拦截: %s", reason))
+		}
+
+		// Usage is cumulative, so the last event has the totals
+		if s := event.stats(); s.InputTokens > 0 || s.OutputTokens > 0 {
+			stats.InputTokens, stats.OutputTokens = s.InputTokens, s.OutputTokens
+		}
+		finish := ""
+		if len(event.Candidates) > 0 {
+			finish = event.Candidates[0].FinishReason
+			if finish != "" {
+				stats.StopReason = finish
+			}
+		}
+		thought, text := event.text()
+
+		if config.EventsNDJSON {
+			if thought != "" {
+				writeEvent(streamEvent{Type: "reasoning", Delta: thought})
+			}
+			if text != "" {
+				writeEvent(streamEvent{Type: "content", Delta: text})
+			}
+			if finish != "" {
+				writeEvent(streamEvent{Type: "finish", FinishReason: finish})
+			}
+		}
+
+		if thought != "" && !config.rawStream() {
+			rs.Reasoning(thought)
+		}
+		if text != "" || (config.ProxyStream && finish != "") {
+			if config.ProxyStream {
+				writeProxyChunk(config.Model, text, geminiFinishReason(finish))
+			} else if !config.EventsNDJSON {
+				text = rs.Content(text)
+			}
+			fullResponse += text
+			answerOutput.Write(text)
+		}
+
+		// Returning closes the response body, which cancels the request
+		if rs.Stopped() {
+			stats.StoppedEarly = true
+			break
+		}
+	}
+
+	if config.ProxyStream {
+		writeProxyDone()
+	} else if config.EventsNDJSON {
+		writeEvent(streamEvent{Type: "done"})
+	} else {
+		tail := rs.Finish()
+		fullResponse += tail
+		answerOutput.Write(tail)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fullResponse, stats, streamReadError(err)
+	}
+
+	return fullResponse, stats, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// highlightStyle marks --highlight matches: black on a yellow background
+const highlightStyle = "\033[30;43m"
+
+// compileHighlight combines the --highlight patterns into one case-insensitive
+// regexp. Patterns written as /regex/ are regular expressions; anything else
+// is matched literally.
+func compileHighlight(patterns []string) (*regexp.Regexp, error) {
+	exprs := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		expr := regexp.QuoteMeta(p)
+		if len(p) >= 2 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			expr = p[1 : len(p)-1]
+			if _, err := regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("--highlight 的正则表达式 %q 无效: %w", p, err)
+			}
+		}
+		exprs = append(exprs, "(?:"+expr+")")
+	}
+	return regexp.Compile("(?i)" + strings.Join(exprs, "|"))
+}
+
+// highlightANSI wraps the matches of re in highlightStyle. Only the text
+// between escape sequences is searched, and after each match the styles
+// active before it are restored.
+func highlightANSI(text string, re *regexp.Regexp) string {
+	var b strings.Builder
+	active := "" // Escape sequences since the last reset
+	last := 0
+	for _, loc := range ansiPattern.FindAllStringIndex(text, -1) {
+		b.WriteString(highlightPlain(text[last:loc[0]], re, active))
+		seq := text[loc[0]:loc[1]]
+		b.WriteString(seq)
+		if seq == resetStyle || seq == "\033[m" {
+			active = ""
+		} else if strings.HasSuffix(seq, "m") {
+			active += seq
+		}
+		last = loc[1]
+	}
+	b.WriteString(highlightPlain(text[last:], re, active))
+	return b.String()
+}
+
+// highlightPlain highlights the matches in text without escape sequences
+func highlightPlain(text string, re *regexp.Regexp, active string) string {
+	return re.ReplaceAllStringFunc(text, func(match string) string {
+		if match == "" {
+			return match
+		}
+		return highlightStyle + match + resetStyle + active
+	})
+}
+
+// renderText converts color tags to escape sequences, then renders Markdown
+// for render_markdown, and applies --highlight as the final pass. Streams
+// keep their open tags in config.styles, since a tag and its closing tag
+// usually arrive in different deltas, and their code block state in
+// config.markdown.
+func renderText(config *Config, text string) string {
+	styles := config.styles
+	if styles == nil {
+		styles = &styleStack{}
+	}
+	text = formatTags(text, styles)
+	if config.RenderMarkdown && colorEnabled() {
+		markdown := config.markdown
+		if markdown == nil {
+			markdown = &markdownRenderer{}
+		}
+		text = markdown.render(text)
+	}
+	patterns := highlightPatterns(config)
+	if len(patterns) == 0 || !colorEnabled() {
+		return text
+	}
+	re, err := compileHighlight(patterns)
+	if err != nil {
+		return text // Rejected at startup
+	}
+	return highlightANSI(text, re)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// imageDetails are the values OpenAI accepts for an image's detail level. Low
+// detail is much cheaper; high detail looks at the image in full resolution.
+var imageDetails = []string{"low", "high", "auto"}
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// validateImageDetail checks an image detail level
+func validateImageDetail(detail string) error {
+	for _, d := range imageDetails {
+		if detail == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("图片精度 %q 无效，可选: %s", detail, strings.Join(imageDetails, ", "))
+}
+
+// imageURL returns the URL sent for an image. Remote and data URLs are used
+// as they are; local files are embedded as base64 data URLs.
+func imageURL(image string) (string, error) {
+	if strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") || strings.HasPrefix(image, "data:") {
+		return image, nil
+	}
+	data, err := os.ReadFile(image)
+	if err != nil {
+		return "", fmt.Errorf("读取图片失败: %w", err)
+	}
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", fmt.Errorf("%s 不是支持的图片格式 (%s)", image, mimeType)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// anthropicImageTypes are the image formats the Anthropic API accepts
+var anthropicImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// anthropicImageSource returns the source of an Anthropic image block:
+// remote images by URL, local files and data URLs as base64 data
+func anthropicImageSource(image string) (map[string]interface{}, error) {
+	if strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return map[string]interface{}{"type": "url", "url": image}, nil
+	}
+	url, err := imageURL(image)
+	if err != nil {
+		return nil, err
+	}
+	header, data, _ := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mimeType, isBase64 := strings.CutSuffix(header, ";base64")
+	if !isBase64 {
+		return nil, fmt.Errorf("%s 不是 base64 编码的 data URL", image)
+	}
+	supported := false
+	for _, t := range anthropicImageTypes {
+		supported = supported || mimeType == t
+	}
+	if !supported {
+		return nil, fmt.Errorf("Anthropic 不支持 %s 格式的图片，可用: %s", mimeType, strings.Join(anthropicImageTypes, ", "))
+	}
+	return map[string]interface{}{"type": "base64", "media_type": mimeType, "data": data}, nil
+}
+
+// withContentParts returns the messages with the final user message, added
+// if there is none, replaced by the content parts built from its text
+func withContentParts(messages []Message, parts func(text string) ([]interface{}, error)) ([]interface{}, error) {
+	last := len(messages) - 1
+	if last < 0 || messages[last].Role != "user" {
+		messages = append(messages, Message{Role: "user"})
+		last++
+	}
+	content, err := parts(messages[last].Content)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]interface{}, 0, len(messages))
+	for _, m := range messages[:last] {
+		result = append(result, m)
+	}
+	return append(result, map[string]interface{}{"role": "user", "content": content}), nil
+}
+
+// attachImages returns the OpenAI messages with the images added, all at the
+// same detail level, to the final user message as image_url content parts
+func attachImages(messages []Message, config *Config) ([]interface{}, error) {
+	return withContentParts(messages, func(text string) ([]interface{}, error) {
+		parts := []interface{}{}
+		if text != "" {
+			parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+		}
+		for _, image := range config.Images {
+			url, err := imageURL(image)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, map[string]interface{}{
+				"type": "image_url",
+				"image_url": map[string]interface{}{
+					"url":    url,
+					"detail": config.ImageDetail,
+				},
+			})
+		}
+		return parts, nil
+	})
+}
+
+// attachAnthropicImages returns the Anthropic messages with the images added
+// to the final user message as image blocks. The images come before the
+// question, as Anthropic recommends.
+func attachAnthropicImages(messages []Message, config *Config) ([]interface{}, error) {
+	return withContentParts(messages, func(text string) ([]interface{}, error) {
+		parts := []interface{}{}
+		for _, image := range config.Images {
+			source, err := anthropicImageSource(image)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, map[string]interface{}{"type": "image", "source": source})
+		}
+		if text != "" {
+			parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+		}
+		return parts, nil
+	})
+}
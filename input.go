@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// truncatedMarker replaces the middle of an input cut down to max_input_chars
+const truncatedMarker = "\n...[truncated]...\n"
+
+// truncateInput shortens text to at most max characters, not counting the
+// marker, by keeping its head and tail, so that both the start and the
+// latest lines of a log are sent. It reports whether anything was cut.
+func truncateInput(text string, max int) (string, bool) {
+	runes := []rune(text)
+	if max <= 0 || len(runes) <= max {
+		return text, false
+	}
+	head := max / 2
+	tail := max - head
+	return string(runes[:head]) + truncatedMarker + string(runes[len(runes)-tail:]), true
+}
+
+// limitInput applies max_input_chars to the question, with a notice on
+// stderr when it had to be shortened
+func limitInput(question string, config *Config) string {
+	limited, cut := truncateInput(question, config.MaxInputChars)
+	if cut {
+		fmt.Fprintf(os.Stderr, "输入有 %d 个字符，超过 max_input_chars=%d，已截去中间部分\n", len([]rune(question)), config.MaxInputChars)
+	}
+	return limited
+}
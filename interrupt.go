@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+)
+
+// errInterrupted is returned by a stream cancelled with Ctrl-C, together
+// with the part of the answer received until then
+var errInterrupted = errors.New("已中断")
+
+// interruptContext returns a context that the first Ctrl-C cancels instead
+// of killing wen, so a stream can end cleanly. A second Ctrl-C exits at once
+// as usual. Calling stop removes the handler.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// interrupted reports whether the requests of config were cancelled
+func interrupted(config *Config) bool {
+	return config.Context != nil && config.Context.Err() != nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// repeatedConfigKeys are the settings that may be given several times, so a
+// JSON array becomes one line per element. Other arrays are joined with
+// commas, as in best_of_models=a, b.
+var repeatedConfigKeys = map[string]bool{
+	"strip_prefixes":    true,
+	"citation_patterns": true,
+	"postprocess":       true,
+	"price_override":    true,
+}
+
+// jsonConfigLines turns a JSON config into key=value lines, so it is checked
+// exactly like a key=value config and uses the same defaults. The keys are
+// the config keys, which are also the json tags of Config; profiles are
+// objects under "profiles":
+//
+//	{"model": "gpt-4o-mini", "profiles": {"smart": {"model": "gpt-4o"}}}
+//
+// Objects such as extra_headers become one name:value line per entry, and
+// safety_settings is kept as JSON.
+func jsonConfigLines(data []byte) ([]string, configSections, error) {
+	var sections configSections
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, sections, fmt.Errorf("解析 JSON 配置失败: %w", err)
+	}
+
+	var profiles map[string]map[string]json.RawMessage
+	if raw, found := root["profiles"]; found {
+		if err := json.Unmarshal(raw, &profiles); err != nil {
+			return nil, sections, fmt.Errorf("JSON 配置中的 profiles 应为对象，每个 profile 也是一个对象")
+		}
+		delete(root, "profiles")
+	}
+
+	top, err := jsonObjectLines(root)
+	if err != nil {
+		return nil, sections, err
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines, err := jsonObjectLines(profiles[name])
+		if err != nil {
+			return nil, sections, fmt.Errorf("profile %s: %w", name, err)
+		}
+		sections.add(name, "")
+		for _, line := range lines {
+			sections.add(name, line)
+		}
+	}
+	return top, sections, nil
+}
+
+// jsonObjectLines converts the settings of one JSON object, in key order
+func jsonObjectLines(object map[string]json.RawMessage) ([]string, error) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		raw := object[key]
+		if key == "safety_settings" {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, raw); err != nil {
+				return nil, fmt.Errorf("safety_settings 不是有效的 JSON")
+			}
+			lines = append(lines, key+"="+compact.String())
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("JSON 配置中的 %s 无效: %w", key, err)
+		}
+		switch v := value.(type) {
+		case nil:
+			// null leaves the default
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, err := jsonScalar(key, item)
+				if err != nil {
+					return nil, err
+				}
+				items[i] = s
+			}
+			if repeatedConfigKeys[key] {
+				for _, item := range items {
+					lines = append(lines, key+"="+item)
+				}
+			} else {
+				lines = append(lines, key+"="+strings.Join(items, ", "))
+			}
+		case map[string]interface{}:
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				s, err := jsonScalar(key, v[name])
+				if err != nil {
+					return nil, err
+				}
+				lines = append(lines, key+"="+name+":"+s)
+			}
+		default:
+			s, err := jsonScalar(key, v)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, key+"="+s)
+		}
+	}
+	return lines, nil
+}
+
+// jsonScalar returns a string, number or boolean as it is written in a
+// key=value config
+func jsonScalar(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", fmt.Errorf("JSON 配置中的 %s 应为字符串、数字或布尔值", key)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// jsonResult is the object --json prints instead of the answer and the
+// timing line. On failure it has the error and no answer.
+type jsonResult struct {
+	Answer         string    `json:"answer"`
+	Reasoning      string    `json:"reasoning,omitempty"`
+	Model          string    `json:"model"`
+	Provider       string    `json:"provider"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	Usage          jsonUsage `json:"usage"`
+	Attempts       int       `json:"attempts,omitempty"`
+	LastStatus     int       `json:"last_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// jsonUsage is the token usage of a jsonResult, zero when the provider
+// reported none
+type jsonUsage struct {
+	InputTokens         int `json:"input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"`
+	CacheReadTokens     int `json:"cache_read_tokens,omitempty"`
+}
+
+// newJSONUsage returns the usage added up in stats
+func newJSONUsage(stats streamStats) jsonUsage {
+	return jsonUsage{
+		InputTokens:         stats.InputTokens,
+		OutputTokens:        stats.OutputTokens,
+		CacheCreationTokens: stats.CacheCreationTokens,
+		CacheReadTokens:     stats.CacheReadTokens,
+	}
+}
+
+// printJSONResult writes result to stdout as a single line. < > and & are
+// not escaped, so answers with code such as a < b stay readable.
+func printJSONResult(result jsonResult) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.Encode(result)
+}
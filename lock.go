@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// withLock runs fn while holding an advisory lock on path, so concurrent wen
+// processes do not interleave reads and writes of shared state. Several
+// readers may hold a shared lock at once; a writer needs an exclusive one.
+// Locking can be turned off with file_locking=false, e.g. on network
+// filesystems where locks are unreliable.
+func withLock(config *Config, path string, exclusive bool, fn func() error) error {
+	if !config.FileLocking {
+		return fn()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("获取文件锁失败: %w", err)
+	}
+	unlock, err := lockFile(path, exclusive)
+	if err != nil {
+		return fmt.Errorf("获取文件锁失败: %w", err)
+	}
+	defer unlock()
+	return fn()
+}
@@ -0,0 +1,37 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	// lockRetryInterval is how often a held lock file is checked again
+	lockRetryInterval = 20 * time.Millisecond
+	// staleLockAge is when a lock file is assumed to be left over from a
+	// process that crashed while holding it
+	staleLockAge = 10 * time.Second
+)
+
+// lockFile locks path by creating it exclusively, waiting while another
+// process holds it. There is no shared mode here; readers lock exclusively.
+func lockFile(path string, exclusive bool) (func(), error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
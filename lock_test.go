@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestWithLockConcurrentWriters increments a counter file from several
+// goroutines, each opening the lock file itself as separate processes do.
+// An update is lost if two of them read the same value.
+func TestWithLockConcurrentWriters(t *testing.T) {
+	config := newConfig()
+	config.FileLocking = true
+	path := filepath.Join(t.TempDir(), "counter.json")
+	if err := os.WriteFile(path, []byte("0"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, increments = 8, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				err := withLock(config, path+".lock", true, func() error {
+					data, err := os.ReadFile(path)
+					if err != nil {
+						return err
+					}
+					var n int
+					if err := json.Unmarshal(data, &n); err != nil {
+						return fmt.Errorf("counter file %q does not parse: %w", data, err)
+					}
+					return os.WriteFile(path, []byte(fmt.Sprint(n+1)), 0o600)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), fmt.Sprint(writers*increments); got != want {
+		t.Errorf("counter = %s, want %s", got, want)
+	}
+}
+
+// TestCacheStoreConcurrentWriters stores answers from several goroutines at
+// once, some for the same request, and checks that every entry is complete
+func TestCacheStoreConcurrentWriters(t *testing.T) {
+	config := newConfig()
+	config.Cache = true
+	config.CacheOnlyDeterministic = false
+	config.CacheDir = t.TempDir()
+
+	const writers = 16
+	shared := []byte(`{"model":"shared"}`)
+	request := func(i int) []byte { return []byte(fmt.Sprintf(`{"model":"m%d"}`, i)) }
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			answer := fmt.Sprintf("answer %d", i)
+			cacheStore(config, request(i), answer)
+			cacheStore(config, shared, answer)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		got, ok := cacheLookup(config, request(i))
+		if want := fmt.Sprintf("answer %d", i); !ok || got != want {
+			t.Errorf("entry %d = %q, %v; want %q", i, got, ok, want)
+		}
+	}
+	got, ok := cacheLookup(config, shared)
+	if !ok {
+		t.Fatal("the shared entry is missing or does not parse")
+	}
+	var valid bool
+	for i := 0; i < writers; i++ {
+		valid = valid || got == fmt.Sprintf("answer %d", i)
+	}
+	if !valid {
+		t.Errorf("shared entry = %q, want one of the stored answers", got)
+	}
+
+	// Temporary files are renamed into place or removed
+	entries, err := os.ReadDir(config.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if name := e.Name(); name != ".lock" && filepath.Ext(name) != ".json" {
+			t.Errorf("left over file %s", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// tokenLogprob is the log probability OpenAI reports for one output token
+type tokenLogprob struct {
+	Token       string  `json:"token"`
+	Logprob     float64 `json:"logprob"`
+	TopLogprobs []struct {
+		Token   string  `json:"token"`
+		Logprob float64 `json:"logprob"`
+	} `json:"top_logprobs"`
+}
+
+// choiceLogprobs is the "logprobs" object of a choice or stream chunk
+type choiceLogprobs struct {
+	Content []tokenLogprob `json:"content"`
+}
+
+// lowConfidence is the probability below which alternatives are listed
+const lowConfidence = 0.5
+
+// confidenceStyle picks a color for a token probability
+func confidenceStyle(p float64) string {
+	switch {
+	case p >= 0.9:
+		return "\033[32m"
+	case p >= lowConfidence:
+		return "\033[33m"
+	default:
+		return "\033[31m"
+	}
+}
+
+// printLogprobs writes the answer to stderr with each token colored by its
+// probability (green ≥ 90%, yellow ≥ 50%, red below), followed by the
+// alternatives considered for the low-confidence tokens
+func printLogprobs(tokens []tokenLogprob) {
+	if len(tokens) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\n逐词概率 (绿 ≥90%, 黄 ≥50%, 红 <50%):\n")
+	for _, t := range tokens {
+		b.WriteString(styleText(confidenceStyle(math.Exp(t.Logprob)), t.Token))
+	}
+	b.WriteString("\n")
+
+	for _, t := range tokens {
+		p := math.Exp(t.Logprob)
+		if p >= lowConfidence || len(t.TopLogprobs) == 0 {
+			continue
+		}
+		alternatives := make([]string, 0, len(t.TopLogprobs))
+		for _, alt := range t.TopLogprobs {
+			alternatives = append(alternatives, fmt.Sprintf("%q %.0f%%", alt.Token, math.Exp(alt.Logprob)*100))
+		}
+		fmt.Fprintf(&b, "  %q %.0f%% → %s\n", t.Token, p*100, strings.Join(alternatives, ", "))
+	}
+
+	fmt.Fprint(os.Stderr, b.String())
+}
@@ -3,132 +3,1127 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"regexp"
 )
 
-// Config holds the configuration from /etc/wen.conf
+// Config holds the configuration from the config file, see configPaths
 type Config struct {
 	Model          string `json:"model"`
 	APIKey         string `json:"api_key"`
 	APIURL         string `json:"api_url"`
-	Provider       string `json:"provider"` // "openai", "anthropic", etc.
+	Provider       string `json:"provider"` // "openai", "anthropic", "gemini", etc.
+	APIVersion     string `json:"api_version"` // api-version query parameter (Azure)
 	PromptTemplate string `json:"prompt_template"`
+	TerminalFormat string `json:"terminal_format_prompt"` // Tag instructions added to non-streaming prompts
 	Stream         bool   `json:"stream"`   // Whether to use streaming API
+	Locale         string `json:"locale"`   // Locale for {{date}}/{{time}} in the prompt, e.g. zh_CN
+
+	// Reasoning display for models that return their chain of thought
+	ShowReasoning      bool   `json:"show_reasoning"`
+	ReasoningLabel     string `json:"reasoning_label"`     // Header printed before the reasoning
+	ReasoningSeparator string `json:"reasoning_separator"` // Printed between reasoning and answer
+
+	// Tool calling (OpenAI only)
+	ToolsFile         string `json:"tools_file"`          // JSON file holding the "tools" array
+	ToolChoice        string `json:"tool_choice"`         // auto, none, required or a function name
+	ParallelToolCalls *bool  `json:"parallel_tool_calls"` // nil means let the API decide
+
+	// Response cache
+	Cache                  bool   `json:"cache"`
+	CacheDir               string `json:"cache_dir"`
+	CacheOnlyDeterministic bool   `json:"cache_only_deterministic"` // Skip requests without temperature 0 or a seed
+
+	FileLocking bool `json:"file_locking"` // Lock shared cache and state files between processes
+
+	TransformCmd string `json:"transform_cmd"` // Command that rewrites the request body (stdin to stdout)
+
+	// Best-of-N selection
+	BestOfModels []string `json:"best_of_models"` // Models the candidates rotate through
+	BestOfKeys   []string `json:"best_of_keys"`   // API keys the candidates rotate through
+	JudgeModel   string   `json:"judge_model"`    // Model picking the best candidate
+
+	FallbackModel string `json:"fallback_large_context_model"` // Retried with when the prompt is too long
+
+	StripPrefixes []string `json:"strip_prefixes"` // Leading filler phrases removed from answers
+	StripMarkdown bool     `json:"strip_markdown"` // Remove Markdown syntax from answers
+
+	RenderMarkdown bool  `json:"render_markdown"` // Show Markdown in answers as terminal styles
+	Wrap           *bool `json:"wrap"`            // Word-wrap non-streamed answers to the terminal width; nil means only on a terminal
+	Copy           bool  `json:"copy"`            // Copy the answer to the clipboard
+
+	ShowCost      bool                  `json:"show_cost"`      // Add the estimated cost to the timing line
+	PriceOverride map[string]modelPrice `json:"price_override"` // USD per million tokens by model prefix, before modelPrices
+
+	// Citation markers and references sections of search-augmented answers
+	StripCitations    bool     `json:"strip_citations"`
+	CitationPatterns  []string `json:"citation_patterns"` // Regexps for inline markers, replacing the default
+	KeepCitationsFile string   `json:"-"`                 // Where the removed citations are saved
+
+	PostProcess []postStep `json:"postprocess"` // Ordered answer transforms, see pipelineSteps
+
+	ImageDetail   string   `json:"image_detail"`   // low, high or auto for images sent with --image
+	StatusLine    bool     `json:"status_line"`    // Show elapsed time and tokens on stderr while streaming
+
+	OmitStreamFalse bool `json:"omit_stream_false"` // Leave "stream" out of non-streaming requests
+
+	// Content safety
+	SafetySettings json.RawMessage `json:"safety_settings"` // Passed through to providers with safety thresholds
+	ModerateInput  bool            `json:"moderate_input"`  // Check the prompt with the OpenAI moderation endpoint first
+	ModerationURL  string          `json:"moderation_url"`
+
+	Temperature *float64 `json:"temperature"` // Sampling temperature; nil leaves the provider default
+	MaxTokens   int      `json:"max_tokens"`  // Longest answer in tokens; 0 leaves the provider default
+
+	SessionMaxMessages int `json:"session_max_messages"` // Messages kept by --session; 0 keeps all
+	MaxInputChars      int `json:"max_input_chars"`      // Longer questions lose their middle; 0 sends them whole
+
+	ExtraHeaders map[string]string `json:"extra_headers"` // Sent with every request
+
+	OTLPEndpoint string `json:"otlp_endpoint"` // OpenTelemetry collector receiving a span per request
+
+	Debug bool `json:"debug"` // Print what is sent to the provider on stderr, also set by WEN_DEBUG
+	Quiet bool `json:"quiet"` // Leave out the timing line
+
+	Timeout           int    `json:"timeout"`             // Seconds to wait for a response, or for a stream to start; 0 waits forever
+	StreamIdleTimeout int    `json:"stream_idle_timeout"` // Seconds a started stream may go without data; 0 waits forever
+	MaxRetries        int    `json:"max_retries"`         // Retries after a 429 or 5xx response
+	Proxy             string `json:"proxy"`               // Proxy URL for API requests, instead of HTTP_PROXY and HTTPS_PROXY
+
+	attempts *attemptInfo      // Counts the requests sent, when set
+	usage    *streamStats      // Adds up the tokens used, when set
+	styles   *styleStack       // Format tags left open by earlier stream deltas, when set
+	markdown *markdownRenderer // Code block state of the streamed answer, when set
+
+	apiKeyUnset []string // Environment variables named in api_key that are not set
+
+	PromptCache bool `json:"prompt_cache"` // Mark the Anthropic system prompt for prompt caching
+
+	AnthropicVersion string `json:"anthropic_version"` // anthropic-version header
+	AnthropicBeta    string `json:"anthropic_beta"`    // anthropic-beta header, comma-separated features
+
+	Referer string `json:"referer"` // OpenRouter HTTP-Referer header, the URL of the app
+	Title   string `json:"title"`   // OpenRouter X-Title header, the name of the app; "wen" by default
+
+	StopAfterCode bool `json:"-"` // End the answer after its first code block
+
+	// Per-invocation settings from the command line
+	ProxyStream  bool `json:"-"` // Write an OpenAI-compatible SSE stream to stdout
+	EventsNDJSON bool `json:"-"` // Write the parsed stream events as JSON lines to stdout
+	Raw          bool `json:"-"` // Print the answer exactly as the model wrote it, see --raw
+	Silent       bool `json:"-"` // Skip the request debug output even with debug on
+	Verbose      bool `json:"-"` // Report extra details on stderr
+	CountRetries bool `json:"-"` // Report how many requests the answer took
+	Logprobs     bool `json:"-"` // Request token log probabilities (OpenAI)
+	TopLogprobs  int  `json:"-"` // Alternatives returned per token with Logprobs
+
+	// OnDelta receives each piece of a streamed answer as it arrives, before
+	// terminal formatting, e.g. to forward it elsewhere. It is called on the
+	// goroutine reading the response, so a slow callback holds up the stream.
+	// When nil the text is formatted and printed to stdout.
+	OnDelta func(text string) `json:"-"`
+
+	// Messages sent before the question, e.g. few-shot examples from --messages
+	Messages []Message `json:"-"`
+
+	// Images sent along with the question (OpenAI vision)
+	Images []string `json:"-"`
+
+	// Patterns highlighted in the displayed answer, from --highlight
+	Highlight []string `json:"-"`
+
+	// Context cancels the requests made with this config, e.g. those losing
+	// a --race. Nil means they are never cancelled.
+	Context context.Context `json:"-"`
+}
+
+// Options holds the per-invocation overrides parsed from the command line
+type Options struct {
+	ToolsFile         string
+	ToolChoice        string
+	ParallelToolCalls optionalBool
+	ProxyStream       bool
+	Output            string
+	OutputAppend      string
+	BestOf            int
+	Verbose           bool
+	Logprobs          bool
+	TopLogprobs       int
+	SinceFile         string
+	Compare           string
+	Refine            bool
+	MessagesFile      string
+	EventsNDJSON      bool
+	StripMarkdown     bool
+	CPUProfile        string
+	Trace             string
+	Images            stringList
+	ImageDetail       string
+	EnvFile           string
+	StatusLine        bool
+	CountRetries      bool
+	StopAfterCode     bool
+	Curl              bool
+	Interactive       bool
+	StripCitations    bool
+	KeepCitationsFile string
+	Highlight         stringList
+	Race              stringList
+	Quiet             bool
+	Model             string
+	Provider          string
+	URL               string
+	ConfigFile        string
+	Profile           string
+	Version           bool
+	Session           string
+	Continue          bool
+	JSON              bool
+	Raw               bool
+	Stream            optionalBool
+	NoStream          bool
+	Copy              bool
+	DryRun            bool
+	System            string
+}
+
+// optionalBool is a boolean flag that remembers whether it was given at all
+type optionalBool struct {
+	set   bool
+	value bool
+}
+
+func (b *optionalBool) String() string {
+	if !b.set {
+		return ""
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.set, b.value = true, v
+	return nil
 }
 
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
 // Default prompt template
 const defaultPromptTemplate = "回答用户问题，务必做到简洁，不要有任何废话。输出纯文本格式(NO MARKDOWN)，适合在终端显示。"
 const promptForTerminal = "使用以下格式添加颜色和样式：<red>红色文本</red>、<green>绿色文本</green>、<blue>蓝色文本</blue>、<bold>粗体文本</bold>、<yellow>黄色文本</yellow>。重要内容请使用颜色或粗体突出显示。"
 
-// processTerminalFormatting converts custom format tags to ANSI escape sequences
-func processTerminalFormatting(text string) string {
-	// Define replacements for custom tags
-	replacements := map[string]string{
-		"<red>":    "\033[31m",
-		"</red>":   "\033[0m",
-		"<green>":  "\033[32m",
-		"</green>": "\033[0m",
-		"<blue>":   "\033[34m",
-		"</blue>":  "\033[0m",
-		"<bold>":   "\033[1m",
-		"</bold>":  "\033[0m",
-		"<yellow>": "\033[33m",
-		"</yellow>": "\033[0m",
+// colorTagPattern matches the color tags with a value: <fg=#RRGGBB> and
+// <bg=#RRGGBB> for true colors, and <color=N> for the 256-color palette
+var colorTagPattern = regexp.MustCompile(`<(fg|bg)=#([0-9a-fA-F]{6})>|<color=(\d{1,3})>`)
+
+// colorTagSequence returns the escape sequence for a colorTagPattern match,
+// or the match itself when the palette index is out of range
+func colorTagSequence(tag string) string {
+	m := colorTagPattern.FindStringSubmatch(tag)
+	if m[3] != "" {
+		n, _ := strconv.Atoi(m[3])
+		if n > 255 {
+			return tag
+		}
+		return fmt.Sprintf("\033[38;5;%dm", n)
 	}
-	
-	result := text
-	for tag, ansi := range replacements {
-		result = strings.ReplaceAll(result, tag, ansi)
+	rgb, _ := strconv.ParseUint(m[2], 16, 32)
+	layer := 38
+	if m[1] == "bg" {
+		layer = 48
+	}
+	return fmt.Sprintf("\033[%d;2;%d;%d;%dm", layer, rgb>>16, rgb>>8&0xff, rgb&0xff)
+}
+
+// styleTags maps the named format tags to their escape sequences
+var styleTags = map[string]string{
+	"red":    "\033[31m",
+	"green":  "\033[32m",
+	"blue":   "\033[34m",
+	"bold":   "\033[1m",
+	"yellow": "\033[33m",
+}
+
+// formatTagPattern matches every format tag: the named tags and their closing
+// tags, the color tags and the generic </>
+var formatTagPattern = regexp.MustCompile(`</?(red|green|blue|bold|yellow)>|</>|` + colorTagPattern.String())
+
+// openTag is a format tag that has not been closed yet
+type openTag struct {
+	name string // e.g. "red", or "fg" for <fg=#RRGGBB>
+	seq  string
+}
+
+// styleStack tracks the open format tags. A closing tag resets the terminal,
+// so the styles of the tags still open are emitted again after it: in
+// <bold><red>x</red>y</bold> the y stays bold.
+type styleStack struct {
+	open []openTag
+}
+
+// format converts the format tags in text to escape sequences
+func (s *styleStack) format(text string) string {
+	return formatTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		if tag == "</>" {
+			return s.close("")
+		}
+		if name, found := strings.CutPrefix(tag, "</"); found {
+			return s.close(strings.TrimSuffix(name, ">"))
+		}
+		name := strings.Trim(tag, "<>")
+		seq, found := styleTags[name]
+		if !found {
+			if seq = colorTagSequence(tag); seq == tag {
+				return tag
+			}
+			name, _, _ = strings.Cut(name, "=")
+		}
+		s.open = append(s.open, openTag{name: name, seq: seq})
+		return seq
+	})
+}
+
+// close ends the innermost open tag called name, or the innermost tag of any
+// kind when name is empty, and returns a reset followed by the styles that
+// are still open
+func (s *styleStack) close(name string) string {
+	for i := len(s.open) - 1; i >= 0; i-- {
+		if name == "" || s.open[i].name == name {
+			s.open = append(s.open[:i], s.open[i+1:]...)
+			break
+		}
 	}
+	seq := resetStyle
+	for _, t := range s.open {
+		seq += t.seq
+	}
+	return seq
+}
+
+// processTerminalFormatting converts custom format tags to ANSI escape sequences
+func processTerminalFormatting(text string) string {
+	return formatTags(text, &styleStack{})
+}
+
+// formatTags converts custom format tags to ANSI escape sequences, with the
+// tags opened by earlier text in styles
+func formatTags(text string, styles *styleStack) string {
+	result := styles.format(text)
 	
 	// Also handle any raw escape sequences that might be in the text
 	// Convert \e to the actual escape character
 	re := regexp.MustCompile(`\\e\[(\d+)m`)
 	result = re.ReplaceAllString(result, "\033[$1m")
+
+	// Without color support the tags are dropped instead of converted
+	if !colorEnabled() {
+		result = stripANSI(result)
+	}
 	
 	return result
 }
 
+// newFlagSet declares every command-line option wen understands
+func newFlagSet(opts *Options) *flag.FlagSet {
+	fs := flag.NewFlagSet("wen", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.ToolsFile, "tools", "", "工具定义 JSON 文件 (OpenAI tools 数组)")
+	fs.StringVar(&opts.ToolChoice, "tool-choice", "", "工具选择: auto、none、required 或函数名")
+	fs.Var(&opts.ParallelToolCalls, "parallel-tool-calls", "是否允许并行调用工具 (true/false)")
+	fs.BoolVar(&opts.ProxyStream, "proxy-stream", false, "以 OpenAI 兼容的 SSE 格式把流式响应输出到标准输出")
+	fs.BoolVar(&opts.EventsNDJSON, "events-ndjson", false, "把解析后的流式事件逐行以 JSON 输出到标准输出")
+	fs.StringVar(&opts.Output, "out", "", "同时把回答保存到文件 (覆盖)")
+	fs.StringVar(&opts.Output, "output", "", "同时把回答保存到文件 (覆盖)")
+	fs.StringVar(&opts.OutputAppend, "output-append", "", "同时把回答追加到文件")
+	fs.Var(&opts.Race, "race", "同时向多个 提供商/模型 提问，显示最先返回的回答，可多次指定")
+	fs.IntVar(&opts.BestOf, "best-of", 0, "同时生成 N 个回答，并由评审模型选出最好的一个")
+	fs.StringVar(&opts.Model, "m", "", "本次使用的模型，覆盖配置中的 model")
+	fs.StringVar(&opts.Model, "model", "", "本次使用的模型，覆盖配置中的 model")
+	fs.StringVar(&opts.Provider, "p", "", "本次使用的提供商，覆盖配置中的 provider")
+	fs.StringVar(&opts.Provider, "provider", "", "本次使用的提供商，覆盖配置中的 provider")
+	fs.StringVar(&opts.URL, "url", "", "本次使用的 API 地址，覆盖配置中的 api_url")
+	fs.StringVar(&opts.System, "system", "", "本次使用的系统提示，代替配置中的 prompt_template")
+	fs.Var(&opts.Stream, "stream", "本次使用流式输出，覆盖配置中的 stream")
+	fs.BoolVar(&opts.NoStream, "no-stream", false, "本次不使用流式输出，覆盖配置中的 stream")
+	fs.BoolVar(&opts.Quiet, "q", false, "不显示耗时")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "不显示耗时")
+	fs.BoolVar(&opts.Verbose, "v", false, "在标准错误输出显示更多信息")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "在标准错误输出显示更多信息")
+	fs.BoolVar(&opts.Logprobs, "logprobs", false, "请求逐词对数概率，配合 -v 显示 (OpenAI)")
+	fs.IntVar(&opts.TopLogprobs, "top-logprobs", 0, "每个词返回的候选词数量 (0-20)，隐含 --logprobs")
+	fs.StringVar(&opts.SinceFile, "since-file", "", "只发送日志文件自上次运行以来新增的内容")
+	fs.StringVar(&opts.Compare, "compare", "", "同时用另一个模型回答，并显示两个回答的差异")
+	fs.BoolVar(&opts.Refine, "refine", false, "让模型改进自己的回答，并显示改动")
+	fs.BoolVar(&opts.StripMarkdown, "strip-markdown", false, "去掉回答中的 Markdown 语法")
+	fs.BoolVar(&opts.StripCitations, "strip-citations", false, "去掉回答中的引用标记 (如 [1]) 和末尾的参考资料")
+	fs.StringVar(&opts.KeepCitationsFile, "keep-citations-file", "", "把去掉的引用保存到文件，隐含 --strip-citations")
+	fs.StringVar(&opts.MessagesFile, "messages", "", "从 JSON 文件读取 [{role, content}] 消息列表，放在问题之前发送")
+	fs.Var(&opts.Highlight, "highlight", "高亮回答中匹配的文字，/正则/ 或普通文字，可多次指定")
+	fs.Var(&opts.Images, "image", "随问题发送的图片文件或 URL，可多次指定 (OpenAI)")
+	fs.StringVar(&opts.ImageDetail, "image-detail", "", "图片精度: low、high 或 auto（默认）")
+	fs.BoolVar(&opts.Interactive, "i", false, "交互模式：逐行读取问题，支持 /set 变量和 /memory 记忆")
+	fs.BoolVar(&opts.Curl, "curl", false, "不发送请求，而是打印等效的 curl 命令")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "不发送请求，而是打印将要发送的请求头和请求体")
+	fs.BoolVar(&opts.StopAfterCode, "stop-after-code", false, "第一个代码块结束后立即停止生成")
+	fs.BoolVar(&opts.CountRetries, "count-retries", false, "在标准错误输出显示请求次数和最后的状态码")
+	fs.BoolVar(&opts.StatusLine, "status", false, "流式输出时在标准错误输出显示耗时和估算的 token 数")
+	fs.BoolVar(&opts.Copy, "copy", false, "把回答（去掉颜色）复制到剪贴板")
+	fs.BoolVar(&opts.Version, "version", false, "显示版本信息后退出")
+	fs.BoolVar(&opts.JSON, "json", false, "以一个 JSON 对象输出回答、模型、提供商、耗时和用量")
+	fs.BoolVar(&opts.Raw, "raw", false, "只原样输出模型的回答，不转换颜色、不换行、不显示耗时")
+	fs.StringVar(&opts.Session, "session", "", "接着名为此名称的会话提问，并把问答保存到会话中")
+	fs.BoolVar(&opts.Continue, "c", false, "接着上一次的问题和回答继续提问")
+	fs.BoolVar(&opts.Continue, "continue", false, "接着上一次的问题和回答继续提问")
+	fs.StringVar(&opts.ConfigFile, "config", "", "使用此配置文件，不再按默认顺序查找")
+	fs.StringVar(&opts.Profile, "profile", "", "使用配置文件中 [profile 名称] 段的设置")
+	fs.StringVar(&opts.EnvFile, "env-file", "", "配置中没有 api_key 时从此 .env 文件读取（默认 ./.env）")
+	fs.StringVar(&opts.CPUProfile, "cpuprofile", "", "把 CPU 性能分析数据 (pprof) 写入文件")
+	fs.StringVar(&opts.Trace, "trace", "", "把执行跟踪数据 (runtime/trace) 写入文件")
+	return fs
+}
+
+// parseArgs separates flags from the words of the question. Flags may appear
+// anywhere on the command line; everything after "--" is question text.
+// Subcommands pass extra to declare their own flags next to the global ones.
+func parseArgs(args []string, extra func(fs *flag.FlagSet)) (*Options, []string, error) {
+	opts := &Options{}
+	fs := newFlagSet(opts)
+	if extra != nil {
+		extra(fs)
+	}
+
+	var words []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			words = append(words, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		words = append(words, rest[0])
+		args = rest[1:]
+	}
+
+	return opts, words, nil
+}
+
+// printUsage writes the usage line and the option list to stderr
+func printUsage() {
+	fs := newFlagSet(&Options{})
+	fs.SetOutput(os.Stderr)
+	fmt.Fprintln(os.Stderr, "使用方式: ./wen [选项] <问题>")
+	fmt.Fprintln(os.Stderr, "          ./wen -i [选项]")
+	fmt.Fprintln(os.Stderr, "          ./wen bench [--runs N] [--json] [选项] <问题>")
+	fmt.Fprintln(os.Stderr, "          ./wen watch [选项] <文件> <问题>")
+	fmt.Fprintln(os.Stderr, "          ./wen providers [--json]")
+	fmt.Fprintln(os.Stderr, "          ./wen prompt-cache-warm [选项]")
+	fmt.Fprintln(os.Stderr, "          ./wen run [选项] <文件.wen> [名称=值...]")
+	fs.PrintDefaults()
+}
+
+// outputPath returns the file the answer should be saved to and whether it
+// is appended to rather than overwritten
+func (opts *Options) outputPath() (string, bool) {
+	if opts.OutputAppend != "" {
+		return opts.OutputAppend, true
+	}
+	return opts.Output, false
+}
+
+// applyOptions overrides config values with the ones given on the command
+// line. It fails when --provider names a provider that cannot be used, or
+// an option value is out of range.
+func applyOptions(config *Config, opts *Options) error {
+	if opts.TopLogprobs < 0 || opts.TopLogprobs > 20 {
+		return fmt.Errorf("--top-logprobs 应在 0 到 20 之间，而不是 %d", opts.TopLogprobs)
+	}
+	// A different provider brings its own URL and key, so it goes first
+	if opts.Provider != "" {
+		if err := switchProvider(config, opts.Provider, opts.EnvFile); err != nil {
+			return fmt.Errorf("--provider: %w", err)
+		}
+	}
+	// Set after the provider, which would replace it with its default
+	if opts.URL != "" {
+		config.APIURL = opts.URL
+	}
+	if opts.Model != "" {
+		config.Model = opts.Model
+	}
+	if opts.System != "" {
+		// The terminal format instruction is still added when not streaming
+		config.PromptTemplate = opts.System
+	}
+	if opts.ToolsFile != "" {
+		config.ToolsFile = opts.ToolsFile
+	}
+	if opts.ToolChoice != "" {
+		config.ToolChoice = opts.ToolChoice
+	}
+	if opts.ParallelToolCalls.set {
+		v := opts.ParallelToolCalls.value
+		config.ParallelToolCalls = &v
+	}
+	if opts.Stream.set {
+		config.Stream = opts.Stream.value
+	}
+	if opts.NoStream {
+		config.Stream = false
+	}
+	if opts.Verbose {
+		config.Verbose = true
+	}
+	if opts.Quiet {
+		config.Quiet = true
+	}
+	if opts.StripMarkdown {
+		config.StripMarkdown = true
+	}
+	if opts.StripCitations || opts.KeepCitationsFile != "" {
+		config.StripCitations = true
+		config.KeepCitationsFile = opts.KeepCitationsFile
+	}
+	if opts.StatusLine {
+		config.StatusLine = true
+	}
+	if opts.CountRetries {
+		config.CountRetries = true
+	}
+	if opts.StopAfterCode {
+		config.StopAfterCode = true
+	}
+	if opts.Copy {
+		config.Copy = true
+	}
+	config.Images = opts.Images
+	config.Highlight = opts.Highlight
+	if opts.ImageDetail != "" {
+		config.ImageDetail = opts.ImageDetail
+	}
+	if opts.Logprobs || opts.TopLogprobs > 0 {
+		config.Logprobs = true
+		config.TopLogprobs = opts.TopLogprobs
+	}
+	if opts.BestOf > 1 || opts.Compare != "" || opts.Refine || len(opts.Race) > 0 {
+		// These modes need complete answers before anything can be shown
+		config.Stream = false
+	}
+	if opts.ProxyStream || opts.EventsNDJSON {
+		// Forwarding events only makes sense for a streamed response
+		config.ProxyStream = opts.ProxyStream
+		config.EventsNDJSON = opts.EventsNDJSON && !opts.ProxyStream
+		config.Silent = true
+		config.Stream = true
+	}
+	return nil
+}
+
+// exit stops profiling and sends pending telemetry before exiting, since
+// os.Exit skips deferred calls
+func exit(code int) {
+	flushTelemetry()
+	stopProfiling()
+	os.Exit(code)
+}
+
 func main() {
-	// Check if arguments are provided
-	if len(os.Args) < 2 {
-		fmt.Println("使用方式: ./wen <问题>")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "providers":
+			os.Exit(runProviders(os.Args[2:]))
+		case "prompt-cache-warm":
+			os.Exit(runCacheWarm(os.Args[2:]))
+		case "run":
+			os.Exit(runPromptFile(os.Args[2:]))
+		}
+	}
+
+	opts, args, err := parseArgs(os.Args[1:], nil)
+	if err == flag.ErrHelp {
+		printUsage()
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("参数错误: %v\n", err)
+		printUsage()
+		os.Exit(1)
+	}
+	if opts.Version {
+		printVersion()
+		os.Exit(0)
+	}
+
+	if err := startProfiling(opts); err != nil {
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
+	defer stopProfiling()
+	defer flushTelemetry()
+
+	// Check if arguments are provided
+	if len(args) < 1 && opts.SinceFile == "" && opts.MessagesFile == "" && !opts.Interactive {
+		printUsage()
+		exit(1)
+	}
 
 	// Load configuration
-	config, err := loadConfig("/etc/wen.conf")
+	config, err := loadDefaultConfig(opts.ConfigFile, opts.Profile, opts.EnvFile)
 	if err != nil {
-		// Try to load from local test.conf if /etc/wen.conf is not available
-		config, err = loadConfig("./test.conf")
+		fmt.Printf("无法加载配置文件: %v\n", err)
+		exit(1)
+	}
+	if err := applyOptions(config, opts); err != nil {
+		fmt.Printf("%v\n", err)
+		exit(1)
+	}
+
+	if err := validateImageDetail(config.ImageDetail); err != nil {
+		fmt.Printf("%v\n", err)
+		exit(1)
+	}
+	if len(config.Highlight) > 0 {
+		if _, err := compileHighlight(config.Highlight); err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+	}
+	if opts.MessagesFile != "" {
+		config.Messages, err = loadMessages(opts.MessagesFile, config.Provider)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+	}
+
+	// A session continues after the preset messages
+	var sessionFile string
+	if opts.Session != "" {
+		if opts.Interactive {
+			fmt.Println("--session 不能与 -i 一起使用")
+			exit(1)
+		}
+		var history []Message
+		if sessionFile, err = sessionPath(opts.Session); err == nil {
+			history, err = loadSession(config, sessionFile)
+		}
 		if err != nil {
-			fmt.Printf("无法加载配置文件: %v\n", err)
-			os.Exit(1)
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+		config.Messages = append(config.Messages, history...)
+	}
+
+	// --continue sends the last exchange along, as a session of one turn
+	lastFile, lastErr := lastExchangePath()
+	if opts.Continue {
+		if opts.Interactive || opts.Session != "" {
+			fmt.Println("--continue 不能与 -i 或 --session 一起使用")
+			exit(1)
+		}
+		if lastErr != nil {
+			fmt.Printf("%v\n", lastErr)
+			exit(1)
+		}
+		last, err := loadSession(config, lastFile)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+		config.Messages = append(config.Messages, last...)
+	}
+
+	if opts.JSON || opts.Raw {
+		mode := "--json"
+		if !opts.JSON {
+			mode = "--raw"
+		}
+		for _, other := range []struct {
+			set  bool
+			name string
+		}{
+			{opts.JSON && opts.Raw, "--raw"},
+			{opts.Interactive, "-i"},
+			{opts.Compare != "", "--compare"},
+			{opts.Refine, "--refine"},
+			{opts.Curl, "--curl"},
+			{opts.ProxyStream, "--proxy-stream"},
+			{opts.EventsNDJSON, "--events-ndjson"},
+		} {
+			if other.set {
+				fmt.Printf("%s 不能与 %s 一起使用\n", mode, other.name)
+				exit(1)
+			}
 		}
+		// The answer is printed in one piece once it is complete
+		config.Stream = false
+		config.Silent = true
+	}
+	if opts.Raw {
+		config.Raw = true
+		config.Quiet = true
+	}
+
+	if opts.Interactive {
+		exit(runREPL(config))
 	}
 
 	// Get the user question by joining all arguments
-	question := strings.Join(os.Args[1:], " ")
+	question := strings.Join(args, " ")
+
+	var commitSinceFile func() error
+	if opts.SinceFile != "" {
+		var content string
+		content, commitSinceFile, err = readSinceLast(opts.SinceFile, config)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+		if strings.TrimSpace(content) == "" {
+			fmt.Fprintln(os.Stderr, "日志文件没有新增内容")
+			commitSinceFile()
+			return
+		}
+		if question == "" {
+			question = defaultLogPrompt
+		}
+		question += "\n\n" + content
+	}
+	question = limitInput(question, config)
+
+	// Print the request instead of sending it
+	if opts.Curl {
+		config.Silent = true
+		req, requestBody, err := buildRequest(question, config, config.Stream)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+		fmt.Println(curlCommand(req, requestBody, config.APIKey))
+		return
+	}
+	if opts.DryRun {
+		config.Silent = true
+		req, requestBody, err := buildRequest(question, config, config.Stream)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+		fmt.Print(dryRunRequest(req, requestBody, config.APIKey))
+		return
+	}
+
+	if path, appendMode := opts.outputPath(); path != "" {
+		answerOutput, err = openAnswerWriter(path, appendMode, question)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+	}
+
+	if len(config.SafetySettings) > 0 {
+		if p, ok := lookupProvider(config.Provider); ok && !p.supports("safety") {
+			fmt.Fprintf(os.Stderr, "注意: %s 不支持 safety_settings，该设置将被忽略\n", config.Provider)
+		}
+	}
+	if config.ModerateInput {
+		if err := moderateInput(question, config); err != nil {
+			answerOutput.Close()
+			fmt.Printf("%v\n", err)
+			exit(1)
+		}
+	}
 
 	startTime := time.Now()
 	var answer string
 	var err2 error
+	var attempts attemptInfo
+	var usage streamStats
+	config.usage = &usage
+	printed := false // Whether the mode already displayed its result
 
 	// Use streaming or non-streaming API based on config
-	if config.Stream {
-		answer, err2 = streamAI(question, config)
+	if len(opts.Race) > 0 {
+		// Copied only now, so the contestants get the whole conversation
+		racers := make([]*Config, len(opts.Race))
+		for i, spec := range opts.Race {
+			if racers[i], err = raceConfig(config, spec, opts.EnvFile); err != nil {
+				answerOutput.Close()
+				fmt.Printf("%v\n", err)
+				exit(1)
+			}
+		}
+		answer, err2 = raceAI(question, racers, opts.Race)
+		for _, c := range racers {
+			config.addUsage(*c.usage)
+		}
+	} else if opts.BestOf > 1 {
+		answer, err2 = bestOfAI(question, config, opts.BestOf)
+	} else if opts.Compare != "" {
+		answer, err2 = compareAI(question, config, opts.Compare)
+		printed = true
+	} else if opts.Refine {
+		answer, err2 = refineAI(question, config)
+		printed = true
+	} else if config.Stream {
+		var stop func()
+		config.Context, stop = interruptContext()
+		answer, attempts, err2 = askWithFallback(question, config, streamAI)
+		stop()
 	} else {
-		answer, err2 = askAI(question, config)
+		stopSpinner := startSpinner(config)
+		answer, attempts, err2 = askWithFallback(question, config, askAI)
+		stopSpinner()
 	}
 
+	if errors.Is(err2, errInterrupted) {
+		// Leave the terminal as it was, with the partial answer on it
+		if colorEnabled() && !config.rawStream() {
+			fmt.Print(resetStyle)
+		}
+		answerOutput.Close()
+		reportAttempts(config, attempts)
+		fmt.Fprintln(os.Stderr, "\n已中断")
+		exit(130)
+	}
 	if err2 != nil {
+		// Keep whatever part of the answer was already written
+		answerOutput.Close()
+		if opts.JSON {
+			printJSONResult(jsonResult{
+				Model:          config.Model,
+				Provider:       config.Provider,
+				ElapsedSeconds: time.Since(startTime).Seconds(),
+				Attempts:       attempts.Attempts,
+				LastStatus:     attempts.LastStatus,
+				Error:          fmt.Sprintf("请求AI失败: %v", err2),
+			})
+			exit(exitCode(err2))
+		}
+		reportAttempts(config, attempts)
+		if config.Raw {
+			// stdout holds nothing but the answer
+			fmt.Fprintf(os.Stderr, "请求AI失败: %v\n", err2)
+			exit(exitCode(err2))
+		}
+		if config.Stream && answer != "" {
+			endStreamedAnswer(answer)
+		}
 		fmt.Printf("请求AI失败: %v\n", err2)
-		os.Exit(1)
+		exit(exitCode(err2))
 	}
 
 	// Only print the answer if not streaming (streaming already prints)
-	if !config.Stream {
-		// Process and print the answer with terminal formatting
-		formattedAnswer := processTerminalFormatting(answer)
-		fmt.Println(formattedAnswer)
+	var result *jsonResult // Printed instead with --json
+	if opts.JSON {
+		var reasoning string
+		reasoning, answer = splitReasoning(answer)
+		answer = filterAnswer(answer, config)
+		answerOutput.Write(answer)
+		result = &jsonResult{
+			Answer:         plainText(answer),
+			Reasoning:      plainText(reasoning),
+			Model:          config.Model,
+			Provider:       config.Provider,
+			ElapsedSeconds: time.Since(startTime).Seconds(),
+			Usage:          newJSONUsage(usage),
+			Attempts:       attempts.Attempts,
+			LastStatus:     attempts.LastStatus,
+		}
+	} else if printed {
+		answerOutput.Write(answer)
+	} else if config.Raw {
+		_, answer = splitReasoning(answer)
+		answer = filterAnswer(answer, config)
+		fmt.Print(answer)
+		answerOutput.Write(answer)
+	} else if !config.Stream {
+		answer = printAnswer(answer, config)
+		answerOutput.Write(answer)
+	} else if !config.rawStream() {
+		endStreamedAnswer(answer)
+	}
+
+	answerOutput.Close()
+
+	if config.Copy {
+		if err := copyToClipboard(plainText(answer)); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	if sessionFile != "" {
+		if err := saveSession(config, sessionFile, question, answer, config.SessionMaxMessages); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+	// Without a cache directory there is nothing for --continue to follow
+	if lastFile != "" {
+		if err := saveSession(config, lastFile, question, answer, 2); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
 	}
 
-	elapsedTime := time.Since(startTime).Seconds()
-	fmt.Printf("\n\033[1m耗时: %.2f 秒\033[0m\n", elapsedTime)
+	// The new log content is only marked as read once it was analyzed
+	if commitSinceFile != nil {
+		if err := commitSinceFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	// The object replaces the timing line
+	if result != nil {
+		printJSONResult(*result)
+		return
+	}
+
+	// Machine-readable streams must stay parseable, so nothing else goes to stdout
+	if config.rawStream() {
+		reportAttempts(config, attempts)
+		return
+	}
+
+	// Timing goes to stderr so redirected output holds only the answer
+	if !config.Quiet {
+		elapsedTime := time.Since(startTime).Seconds()
+		line := fmt.Sprintf("耗时: %.2f 秒", elapsedTime)
+		if tokens := formatUsage(usage); tokens != "" {
+			line += " · " + tokens
+		}
+		if config.ShowCost {
+			if cost := formatCost(usage, config); cost != "" {
+				line += " · " + cost
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\n%s\n", styleText("\033[1m", line))
+	}
+	reportAttempts(config, attempts)
 }
 
-// loadConfig reads and parses the configuration file
-func loadConfig(configPath string) (*Config, error) {
-	file, err := os.Open(configPath)
+// printAnswer prints a complete (non-streamed) answer with terminal
+// formatting and returns the answer without reasoning, after the answer
+// filters were applied
+func printAnswer(answer string, config *Config) string {
+	reasoning, answer := splitReasoning(answer)
+	answer = filterAnswer(answer, config)
+
+	// Process and print the answer with terminal formatting
+	formattedAnswer := renderText(config, renderReasoning(reasoning, answer, config))
+	if wrapEnabled(config) {
+		formattedAnswer = wrapText(formattedAnswer, terminalWidth())
+	}
+	fmt.Println(formattedAnswer)
+	return answer
+}
+
+// endStreamedAnswer ends the line of a streamed answer, which stops wherever
+// the model did, so that what follows always starts on a new line, as it
+// does after printAnswer
+func endStreamedAnswer(answer string) {
+	if !strings.HasSuffix(plainText(answer), "\n") {
+		fmt.Println()
+	}
+}
+
+// configPaths returns the config files wen looks for, in order:
+// $WEN_CONFIG, $XDG_CONFIG_HOME/wen/wen.conf (~/.config/wen/wen.conf by
+// default), ~/.wen.conf, /etc/wen.conf and ./test.conf for development
+func configPaths() []string {
+	var paths []string
+	if path := os.Getenv("WEN_CONFIG"); path != "" {
+		paths = append(paths, path)
+	}
+	if dir := configDir(); dir != "" {
+		paths = append(paths, filepath.Join(dir, "wen.conf"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".wen.conf"))
+	}
+	return append(paths, "/etc/wen.conf", "./test.conf")
+}
+
+// configDir returns wen's per-user config directory, $XDG_CONFIG_HOME/wen or
+// ~/.config/wen, or "" when there is no home directory
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "wen")
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("打开配置文件失败: %w", err)
+		return ""
 	}
-	defer file.Close()
+	return filepath.Join(home, ".config", "wen")
+}
+
+// loadDefaultConfig loads configFile, which must exist, or when it is empty
+// the first readable file of configPaths, with the named profile selected.
+// $WEN_API_KEY overrides the api_key of the config. When there is none, the
+// provider's standard variable is taken from the environment or read from
+// envFile (./.env if empty), so wen also works in project directories that
+// only have a .env file and no wen config at all.
+func loadDefaultConfig(configFile, profile, envFile string) (*Config, error) {
+	var config *Config
+	var openErr error
+	paths := configPaths()
+	if configFile != "" {
+		var err error
+		if config, err = loadConfig(configFile, profile); err != nil {
+			return nil, err
+		}
+		paths = nil
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file.Close()
+		if config, err = loadConfig(path, profile); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		break
+	}
+	if config == nil {
+		if profile != "" {
+			return nil, fmt.Errorf("没有找到配置文件，无法使用 profile %q", profile)
+		}
+		config = newConfig()
+		openErr = fmt.Errorf("没有找到配置文件 (%s)", strings.Join(paths, ", "))
+	}
+
+	if key := os.Getenv("WEN_API_KEY"); key != "" {
+		config.APIKey = key
+	}
+	if p, ok := lookupProvider(config.Provider); ok && p.KeyEnv != "" && config.APIKey == "" {
+		config.APIKey = os.Getenv(p.KeyEnv)
+	}
+	if config.APIKey == "" {
+		if err := applyEnvFile(config, envFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.APIKey == "" && needsKey(config.Provider) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		if len(config.apiKeyUnset) > 0 {
+			return nil, fmt.Errorf("api_key 引用的环境变量 %s 没有设置", strings.Join(config.apiKeyUnset, ", "))
+		}
+		return nil, fmt.Errorf("配置文件中缺少 api_key")
+	}
+	return config, nil
+}
 
-	config := &Config{
+// newConfig returns a config with the default values
+func newConfig() *Config {
+	return &Config{
 		// Default values
 		Model:          "gpt-3.5-turbo",
 		APIURL:         "https://api.openai.com/v1/chat/completions",
 		Provider:       "openai",
+		APIVersion:     defaultAzureAPIVersion,
 		PromptTemplate: defaultPromptTemplate,
+		TerminalFormat: promptForTerminal,
 		Stream:         true, // Default to non-streaming
+
+		AnthropicVersion: defaultAnthropicVersion,
+		Title:            "wen",
+
+		ReasoningLabel:     defaultReasoningLabel,
+		ReasoningSeparator: defaultReasoningSeparator,
+
+		CacheOnlyDeterministic: true,
+		FileLocking:            true,
+
+		ImageDetail: "auto",
+		Timeout:     defaultTimeout,
+		MaxRetries:  defaultMaxRetries,
+
+		SessionMaxMessages: defaultSessionMaxMessages,
 	}
+}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Skip comments and empty lines
-		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+// readPromptFile reads the system prompt named by prompt_file. A leading ~/
+// is the home directory, and relative paths are relative to dir, the
+// directory of the config file.
+func readPromptFile(path, dir string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("无法读取 prompt_file %s: %w", path, err)
+		}
+		path = filepath.Join(home, rest)
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("无法读取 prompt_file: %w", err)
+	}
+	prompt := strings.TrimPrefix(string(data), "\ufeff")
+	return strings.TrimRight(prompt, "\r\n"), nil
+}
+
+// parseExtraHeaders adds the comma-separated Key:Value pairs of an
+// extra_headers value to config.ExtraHeaders
+func parseExtraHeaders(value string, config *Config) error {
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
 			continue
 		}
+		name, v, found := strings.Cut(pair, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return fmt.Errorf("extra_headers 中的 %q 格式错误，应为 名称:值", strings.TrimSpace(pair))
+		}
+		if config.ExtraHeaders == nil {
+			config.ExtraHeaders = map[string]string{}
+		}
+		config.ExtraHeaders[name] = strings.TrimSpace(v)
+	}
+	return nil
+}
+
+// loadConfig reads and parses the configuration file, with the settings of
+// the named profile, if any, applied over the top-level ones. Files ending
+// in .json are JSON configs.
+func loadConfig(configPath, profile string) (*Config, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开配置文件失败: %w", err)
+	}
+	defer file.Close()
+	lines, err := configLines(file, profile, strings.EqualFold(filepath.Ext(configPath), ".json"))
+	if err != nil {
+		return nil, err
+	}
 
+	config := newConfig()
+	urlSet := false
+	promptFile := ""
+	for _, line := range lines {
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) != 2 {
 			continue
@@ -141,169 +1136,496 @@ func loadConfig(configPath string) (*Config, error) {
 		case "model":
 			config.Model = value
 		case "api_key":
-			config.APIKey = value
+			config.APIKey, config.apiKeyUnset = expandEnvRefs(value)
 		case "api_url":
 			config.APIURL = value
+			urlSet = true
+		case "api_version":
+			config.APIVersion = value
 		case "provider":
-			config.Provider = value
+			// Left empty, the provider stays openai
+			if value != "" {
+				if _, ok := lookupProvider(value); !ok {
+					return nil, unknownProviderError(value)
+				}
+				config.Provider = value
+			}
 		case "prompt_template":
 			config.PromptTemplate = value
+		case "prompt_file":
+			promptFile = value
+		case "extra_headers":
+			if err := parseExtraHeaders(value, config); err != nil {
+				return nil, err
+			}
+		case "terminal_format_prompt":
+			// An empty value turns the instruction off
+			config.TerminalFormat = value
 		case "stream":
-			config.Stream = strings.ToLower(value) == "true" || value == "1"
+			config.Stream = parseBool(value)
+		case "locale":
+			config.Locale = value
+		case "show_reasoning":
+			config.ShowReasoning = parseBool(value)
+		case "reasoning_label":
+			config.ReasoningLabel = unescapeValue(value)
+		case "reasoning_separator":
+			config.ReasoningSeparator = unescapeValue(value)
+		case "tools_file":
+			config.ToolsFile = value
+		case "tool_choice":
+			config.ToolChoice = value
+		case "parallel_tool_calls":
+			b := parseBool(value)
+			config.ParallelToolCalls = &b
+		case "cache":
+			config.Cache = parseBool(value)
+		case "cache_dir":
+			config.CacheDir = value
+		case "cache_only_deterministic":
+			config.CacheOnlyDeterministic = parseBool(value)
+		case "file_locking":
+			config.FileLocking = parseBool(value)
+		case "transform_cmd":
+			config.TransformCmd = value
+		case "best_of_models":
+			config.BestOfModels = splitList(value)
+		case "best_of_keys":
+			config.BestOfKeys = splitList(value)
+		case "judge_model":
+			config.JudgeModel = value
+		case "fallback_large_context_model":
+			config.FallbackModel = value
+		case "strip_prefixes":
+			// May be given several times, one pattern per line
+			if _, err := compilePrefix(value); err != nil {
+				return nil, err
+			}
+			config.StripPrefixes = append(config.StripPrefixes, value)
+		case "strip_markdown":
+			config.StripMarkdown = parseBool(value)
+		case "render_markdown":
+			config.RenderMarkdown = parseBool(value)
+		case "wrap":
+			b := parseBool(value)
+			config.Wrap = &b
+		case "copy":
+			config.Copy = parseBool(value)
+		case "show_cost":
+			config.ShowCost = parseBool(value)
+		case "price_override":
+			if err := parsePriceOverride(value, config); err != nil {
+				return nil, err
+			}
+		case "postprocess":
+			// May be given several times; the steps are appended in order
+			steps, err := parsePipeline(value)
+			if err != nil {
+				return nil, err
+			}
+			config.PostProcess = append(config.PostProcess, steps...)
+		case "strip_citations":
+			config.StripCitations = parseBool(value)
+		case "citation_patterns":
+			// May be given several times, one pattern per line
+			if _, err := compileCitationPatterns([]string{value}); err != nil {
+				return nil, err
+			}
+			config.CitationPatterns = append(config.CitationPatterns, value)
+		case "safety_settings":
+			if !json.Valid([]byte(value)) {
+				return nil, fmt.Errorf("safety_settings 不是有效的 JSON")
+			}
+			config.SafetySettings = json.RawMessage(value)
+		case "moderate_input":
+			config.ModerateInput = parseBool(value)
+		case "moderation_url":
+			config.ModerationURL = value
+		case "otlp_endpoint":
+			config.OTLPEndpoint = value
+		case "debug":
+			config.Debug = parseBool(value)
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil || t < 0 {
+				return nil, fmt.Errorf("temperature 必须是非负数: %q", value)
+			}
+			config.Temperature = &t
+		case "session_max_messages":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("session_max_messages 必须是非负整数: %q", value)
+			}
+			config.SessionMaxMessages = n
+		case "max_input_chars":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("max_input_chars 必须是非负整数: %q", value)
+			}
+			config.MaxInputChars = n
+		case "max_tokens":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("max_tokens 必须是非负整数: %q", value)
+			}
+			config.MaxTokens = n
+		case "quiet":
+			config.Quiet = parseBool(value)
+		case "timeout":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("timeout 必须是非负整数（秒）: %q", value)
+			}
+			config.Timeout = seconds
+		case "stream_idle_timeout":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return nil, fmt.Errorf("stream_idle_timeout 必须是非负整数（秒）: %q", value)
+			}
+			config.StreamIdleTimeout = seconds
+		case "max_retries":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("max_retries 必须是非负整数: %q", value)
+			}
+			config.MaxRetries = n
+		case "proxy":
+			if err := parseProxy(value); err != nil {
+				return nil, err
+			}
+			config.Proxy = value
+		case "prompt_cache":
+			config.PromptCache = parseBool(value)
+		case "anthropic_version":
+			config.AnthropicVersion = value
+		case "anthropic_beta":
+			config.AnthropicBeta = value
+		case "referer":
+			config.Referer = value
+		case "title":
+			config.Title = value
+		case "omit_stream_false":
+			config.OmitStreamFalse = parseBool(value)
+		case "status_line":
+			config.StatusLine = parseBool(value)
+		case "image_detail":
+			if err := validateImageDetail(value); err != nil {
+				return nil, err
+			}
+			config.ImageDetail = value
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	// A prompt file replaces prompt_template wherever either appears
+	if promptFile != "" {
+		prompt, err := readPromptFile(promptFile, filepath.Dir(configPath))
+		if err != nil {
+			return nil, err
+		}
+		config.PromptTemplate = prompt
 	}
 
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("配置文件中缺少 api_key")
+	// Without api_url the provider's own endpoint is used. Providers that
+	// have none, like azure and openai-compatible, are left without one
+	// rather than sent to OpenAI.
+	if !urlSet {
+		if p, ok := lookupProvider(config.Provider); ok {
+			config.APIURL = p.DefaultURL
+		}
 	}
 
 	return config, nil
 }
 
-// askAI sends the question to the AI API and returns the answer
-func askAI(question string, config *Config) (string, error) {
-	var requestBody []byte
-	var err error
-
-	// 在非流式模式下，添加终端格式化提示
-	if !config.Stream {
-		config.PromptTemplate = config.PromptTemplate + " " + promptForTerminal
-	}
+// parseBool interprets a config value as a boolean
+func parseBool(value string) bool {
+	return strings.ToLower(value) == "true" || value == "1"
+}
 
-	switch config.Provider {
-	case "openai":
-		requestBody, err = createOpenAIRequest(question, config, false)
-	case "anthropic":
-		requestBody, err = createAnthropicRequest(question, config, false)
-	default:
-		requestBody, err = createOpenAIRequest(question, config, false) // Default to OpenAI
-	}
+// unescapeValue turns \n and \t in a config value into real newlines and tabs
+func unescapeValue(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(value)
+}
 
+// askAI sends the question to the AI API and returns the answer
+func askAI(question string, config *Config) (string, error) {
+	req, requestBody, err := buildRequest(question, config, false)
 	if err != nil {
 		return "", err
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", config.APIURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("创建请求失败: %w", err)
+	if answer, ok := cacheLookup(config, requestBody); ok {
+		return answer, nil
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	span := startSpan(config, false)
+	defer span.End()
 
 	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(req, config, false)
 	if err != nil {
-		return "", fmt.Errorf("发送请求失败: %w", err)
+		return "", span.fail(err)
 	}
 	defer resp.Body.Close()
+	span.setStatus(resp.StatusCode)
 
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %w", err)
+		return "", span.fail(networkFailure(fmt.Errorf("读取响应失败: %w", err)))
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API返回错误: %s", string(body))
+		return "", span.fail(&apiError{StatusCode: resp.StatusCode, Body: body})
 	}
+	stats := responseStats(config.Provider, body)
+	span.setStats(stats)
+	config.addUsage(stats)
 
 	// Parse response based on provider
 	var answer string
 	switch config.Provider {
 	case "anthropic":
 		answer, err = parseAnthropicResponse(body)
+	case "gemini":
+		answer, err = parseGeminiResponse(body)
+	case "ollama":
+		answer, err = parseOllamaResponse(body)
 	default: // Default to OpenAI
 		answer, err = parseOpenAIResponse(body)
+		if err == nil && config.Logprobs && config.Verbose {
+			printLogprobs(parseOpenAILogprobs(body))
+		}
 	}
 
 	if err != nil {
-		return "", err
+		return "", span.fail(err)
 	}
 
+	cacheStore(config, requestBody, answer)
 	return answer, nil
 }
 
 // streamAI sends the question to the AI API and streams the response
 func streamAI(question string, config *Config) (string, error) {
-	var requestBody []byte
-	var err error
-
-	switch config.Provider {
-	case "openai":
-		requestBody, err = createOpenAIRequest(question, config, true)
-	case "anthropic":
-		requestBody, err = createAnthropicRequest(question, config, true)
-	default:
-		requestBody, err = createOpenAIRequest(question, config, true) // Default to OpenAI
-	}
-
+	req, requestBody, err := buildRequest(question, config, true)
 	if err != nil {
 		return "", err
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", config.APIURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return "", fmt.Errorf("创建请求失败: %w", err)
+	if answer, ok := cacheLookup(config, requestBody); ok {
+		if config.ProxyStream {
+			writeProxyChunk(config.Model, answer, "stop")
+			writeProxyDone()
+		} else if config.EventsNDJSON {
+			writeEvent(streamEvent{Type: "content", Delta: answer})
+			writeEvent(streamEvent{Type: "done"})
+		} else {
+			emitDelta(config, answer)
+		}
+		answerOutput.Write(answer)
+		return answer, nil
 	}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-	req.Header.Set("Accept", "text/event-stream")
+	// The status line already runs while waiting for the first token
+	liveStatus = startStatusLine(config)
+	defer func() {
+		liveStatus.Stop()
+		liveStatus = nil
+	}()
+
+	span := startSpan(config, true)
+	defer span.End()
 
 	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doRequest(req, config, true)
 	if err != nil {
-		return "", fmt.Errorf("发送请求失败: %w", err)
+		if interrupted(config) {
+			err = errInterrupted
+		}
+		return "", span.fail(err)
 	}
 	defer resp.Body.Close()
+	span.setStatus(resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API返回错误: %s", string(body))
+		return "", span.fail(&apiError{StatusCode: resp.StatusCode, Body: body})
 	}
 
 	// Process streaming response based on provider
 	var fullResponse string
+	var stats streamStats
 	switch config.Provider {
 	case "anthropic":
-		fullResponse, err = processAnthropicStream(resp.Body)
+		fullResponse, stats, err = processAnthropicStream(resp.Body, config)
+	case "gemini":
+		fullResponse, stats, err = processGeminiStream(resp.Body, config)
+	case "ollama":
+		fullResponse, stats, err = processOllamaStream(resp.Body, config)
 	default: // Default to OpenAI
-		fullResponse, err = processOpenAIStream(resp.Body)
+		fullResponse, stats, err = processOpenAIStream(resp.Body, config)
 	}
 
+	liveStatus.Stop()
+	span.setStats(stats)
+	config.addUsage(stats)
+	if err != nil && interrupted(config) {
+		// The answer so far was already shown and written
+		return fullResponse, span.fail(errInterrupted)
+	}
 	if err != nil {
-		return "", err
+		// The partial answer goes back with the error, as it is already shown
+		return fullResponse, span.fail(err)
 	}
+	reportStreamStats(config, stats)
 
+	cacheStore(config, requestBody, fullResponse)
 	return fullResponse, nil
 }
 
+// buildRequest creates the HTTP request for a question exactly as it is sent,
+// and returns it together with its body
+func buildRequest(question string, config *Config, stream bool) (*http.Request, []byte, error) {
+	var requestBody []byte
+	var err error
+
+	// 在非流式模式下，添加终端格式化提示
+	// The prompt is built here rather than in config, which is reused by
+	// later requests such as the next question in interactive mode
+	prompt := config.PromptTemplate
+	if !stream && !config.Raw && config.TerminalFormat != "" {
+		prompt += " " + config.TerminalFormat
+	}
+	systemPrompt := renderPromptTemplate(prompt, config)
+
+	switch config.Provider {
+	case "openai":
+		requestBody, err = createOpenAIRequest(question, systemPrompt, config, stream)
+	case "anthropic":
+		requestBody, err = createAnthropicRequest(question, systemPrompt, config, stream)
+	case "gemini":
+		requestBody, err = createGeminiRequest(question, systemPrompt, config, stream)
+	case "ollama":
+		requestBody, err = createOllamaRequest(question, systemPrompt, config, stream)
+	default:
+		requestBody, err = createOpenAIRequest(question, systemPrompt, config, stream) // Default to OpenAI
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	requestBody = transformRequest(requestBody, config)
+
+	// Create HTTP request
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	url := config.APIURL
+	switch config.Provider {
+	case "gemini":
+		url = geminiURL(config, stream)
+	case "azure":
+		if url, err = azureURL(config); err != nil {
+			return nil, nil, err
+		}
+	}
+	if url == "" {
+		return nil, nil, fmt.Errorf("使用 %s 时需要配置 api_url，或用 --url 指定", config.Provider)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case config.Provider == "gemini":
+		req.Header.Set("x-goog-api-key", config.APIKey)
+	case config.Provider == "azure":
+		req.Header.Set("api-key", config.APIKey)
+	case config.Provider == "anthropic":
+		req.Header.Set("x-api-key", config.APIKey)
+		req.Header.Set("anthropic-version", config.AnthropicVersion)
+		if config.AnthropicBeta != "" {
+			req.Header.Set("anthropic-beta", config.AnthropicBeta)
+		}
+	case config.Provider == "zhipu":
+		req.Header.Set("Authorization", "Bearer "+zhipuToken(config.APIKey))
+	case config.APIKey != "":
+		req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	}
+	if config.Provider == "openrouter" {
+		if config.Referer != "" {
+			req.Header.Set("HTTP-Referer", config.Referer)
+		}
+		if config.Title != "" {
+			req.Header.Set("X-Title", config.Title)
+		}
+	}
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	for name, value := range config.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+	return req, requestBody, nil
+}
+
+// setStream sets the stream field of a request body. Fields are only added
+// to request bodies when they carry a value, and with omit_stream_false the
+// field is left out of non-streaming requests too, since a few compatible
+// servers reject an explicit "stream": false.
+func setStream(requestBody map[string]interface{}, stream bool, config *Config) {
+	if stream || !config.OmitStreamFalse {
+		requestBody["stream"] = stream
+	}
+}
+
 // createOpenAIRequest creates the request body for OpenAI API
-func createOpenAIRequest(question string, config *Config, stream bool) ([]byte, error) {
+func createOpenAIRequest(question, systemPrompt string, config *Config, stream bool) ([]byte, error) {
+	messages := conversation(question, config)
+	if !hasSystemMessage(messages) && systemPrompt != "" {
+		messages = append([]Message{{Role: "system", Content: systemPrompt}}, messages...)
+	}
 	requestBody := map[string]interface{}{
-		"model": config.Model,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": config.PromptTemplate,
-			},
-			{
-				"role":    "user",
-				"content": question,
-			},
-		},
-		"stream": stream,
+		"model":    config.Model,
+		"messages": messages,
+	}
+	setStream(requestBody, stream, config)
+
+	if len(config.Images) > 0 {
+		withImages, err := attachImages(messages, config)
+		if err != nil {
+			return nil, err
+		}
+		requestBody["messages"] = withImages
+	}
+
+	if err := addOpenAITools(requestBody, config); err != nil {
+		return nil, err
+	}
+
+	if config.Temperature != nil {
+		requestBody["temperature"] = *config.Temperature
+	}
+	if config.MaxTokens > 0 {
+		requestBody["max_tokens"] = config.MaxTokens
+	}
+	// Without this the stream carries no usage. Servers that are merely
+	// compatible with OpenAI may reject the field, so only providers known
+	// to accept it get it.
+	if p, known := lookupProvider(config.Provider); stream && known && p.supports("stream_usage") {
+		requestBody["stream_options"] = map[string]bool{"include_usage": true}
+	}
+
+	if config.Logprobs {
+		requestBody["logprobs"] = true
+		if config.TopLogprobs > 0 {
+			requestBody["top_logprobs"] = config.TopLogprobs
+		}
 	}
 
 	jsonData, err := json.Marshal(requestBody)
@@ -311,39 +1633,58 @@ func createOpenAIRequest(question string, config *Config, stream bool) ([]byte,
 		return nil, err
 	}
 	
-	// 调试打印
-	fmt.Println("\n\033[1m发送给 OpenAI 的内容:\033[0m")
-	fmt.Printf("系统提示: %s\n", config.PromptTemplate)
-	fmt.Printf("用户问题: %s\n", question)
-	fmt.Println()
+	printRequestDebug(config, "OpenAI", systemPrompt, question)
 	
 	return jsonData, nil
 }
 
+// defaultAnthropicVersion is the anthropic-version header sent unless
+// anthropic_version says otherwise
+const defaultAnthropicVersion = "2023-06-01"
+
+// defaultAnthropicMaxTokens is sent as max_tokens to Anthropic, which needs
+// one, unless max_tokens is configured
+const defaultAnthropicMaxTokens = 1024
+
 // createAnthropicRequest creates the request body for Anthropic API
-func createAnthropicRequest(question string, config *Config, stream bool) ([]byte, error) {
+func createAnthropicRequest(question, systemPrompt string, config *Config, stream bool) ([]byte, error) {
+	maxTokens := config.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultAnthropicMaxTokens // Required by the Messages API
+	}
 	requestBody := map[string]interface{}{
-		"model": config.Model,
-		"messages": []map[string]string{
-			{
-				"role":    "user",
-				"content": question,
-			},
-		},
-		"system": config.PromptTemplate,
-		"stream": stream,
+		"model":      config.Model,
+		"messages":   conversation(question, config),
+		"max_tokens": maxTokens,
+	}
+	if len(config.Images) > 0 {
+		withImages, err := attachAnthropicImages(conversation(question, config), config)
+		if err != nil {
+			return nil, err
+		}
+		requestBody["messages"] = withImages
+	}
+	if config.Temperature != nil {
+		requestBody["temperature"] = *config.Temperature
 	}
+	if systemPrompt != "" && config.PromptCache {
+		// A cache breakpoint after the system prompt caches it with everything before it
+		requestBody["system"] = []map[string]interface{}{{
+			"type":          "text",
+			"text":          systemPrompt,
+			"cache_control": map[string]string{"type": "ephemeral"},
+		}}
+	} else if systemPrompt != "" {
+		requestBody["system"] = systemPrompt
+	}
+	setStream(requestBody, stream, config)
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, err
 	}
 	
-	// 调试打印
-	fmt.Println("\n\033[1m发送给 Anthropic 的内容:\033[0m")
-	fmt.Printf("系统提示: %s\n", config.PromptTemplate)
-	fmt.Printf("用户问题: %s\n", question)
-	fmt.Println()
+	printRequestDebug(config, "Anthropic", systemPrompt, question)
 	
 	return jsonData, nil
 }
@@ -353,45 +1694,139 @@ func parseOpenAIResponse(responseBody []byte) (string, error) {
 	var response struct {
 		Choices []struct {
 			Message struct {
-				Content string `json:"content"`
+				Content          string     `json:"content"`
+				ReasoningContent string     `json:"reasoning_content"`
+				ToolCalls        []toolCall `json:"tool_calls"`
+				Refusal          string     `json:"refusal"`
 			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
 	}
 
 	if err := json.Unmarshal(responseBody, &response); err != nil {
-		return "", fmt.Errorf("解析响应失败: %w", err)
+		return "", responseFailure(fmt.Errorf("解析响应失败: %w", err))
 	}
 
 	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("API返回了空的响应")
+		return "", responseFailure(fmt.Errorf("API返回了空的响应"))
+	}
+
+	message := response.Choices[0].Message
+	content := wrapReasoning(message.ReasoningContent, message.Content)
+	if len(message.ToolCalls) > 0 {
+		return content + formatToolCalls(message.ToolCalls), nil
+	}
+	if message.Refusal != "" {
+		return "", apiFailure(fmt.Errorf("模型拒绝回答: %s", message.Refusal))
 	}
+	if content == "" {
+		return "", emptyAnswerError(response.Choices[0].FinishReason)
+	}
+
+	return content, nil
+}
 
-	return response.Choices[0].Message.Content, nil
+// emptyAnswerError explains an answer without content by its finish reason
+func emptyAnswerError(finishReason string) error {
+	switch finishReason {
+	case "content_filter":
+		return apiFailure(fmt.Errorf("回答被内容过滤拦截 (finish_reason: content_filter)"))
+	case "length":
+		return responseFailure(fmt.Errorf("回答在输出任何内容之前就达到了最大 token 数 (finish_reason: length)"))
+	case "":
+		return responseFailure(fmt.Errorf("API返回了空的回答"))
+	}
+	return responseFailure(fmt.Errorf("API返回了空的回答 (finish_reason: %s)", finishReason))
+}
+
+// parseOpenAILogprobs extracts the token log probabilities of the first choice
+func parseOpenAILogprobs(responseBody []byte) []tokenLogprob {
+	var response struct {
+		Choices []struct {
+			Logprobs choiceLogprobs `json:"logprobs"`
+		} `json:"choices"`
+	}
+
+	if err := json.Unmarshal(responseBody, &response); err != nil || len(response.Choices) == 0 {
+		return nil
+	}
+
+	return response.Choices[0].Logprobs.Content
 }
 
 // parseAnthropicResponse parses the response from Anthropic API
 func parseAnthropicResponse(responseBody []byte) (string, error) {
 	var response struct {
 		Content []struct {
-			Text string `json:"text"`
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			Thinking string `json:"thinking"`
 		} `json:"content"`
 	}
 
 	if err := json.Unmarshal(responseBody, &response); err != nil {
-		return "", fmt.Errorf("解析响应失败: %w", err)
+		return "", responseFailure(fmt.Errorf("解析响应失败: %w", err))
 	}
 
 	if len(response.Content) == 0 {
-		return "", fmt.Errorf("API返回了空的响应")
+		return "", responseFailure(fmt.Errorf("API返回了空的响应"))
+	}
+
+	// With extended thinking the text block follows one or more thinking blocks
+	var thinking, text string
+	for _, block := range response.Content {
+		switch block.Type {
+		case "thinking":
+			thinking += block.Thinking
+		default:
+			text += block.Text
+		}
 	}
 
-	return response.Content[0].Text, nil
+	return wrapReasoning(thinking, text), nil
 }
 
-// processOpenAIStream processes the streaming response from OpenAI API
-func processOpenAIStream(responseBody io.Reader) (string, error) {
-	scanner := bufio.NewScanner(responseBody)
+// emitDelta hands a piece of streamed text to config.OnDelta, or prints it
+// with terminal formatting when no callback is set
+func emitDelta(config *Config, text string) {
+	if config.OnDelta != nil {
+		config.OnDelta(text)
+		return
+	}
+	liveStatus.Write(text, renderText(config, text))
+}
+
+// maxStreamLine is the longest line a streaming response may have. Some
+// providers send large events, such as a long code listing, as one line.
+const maxStreamLine = 1 << 20
+
+// newStreamScanner returns a line scanner for a streaming response
+func newStreamScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	return scanner
+}
+
+// streamReadError describes an error reading a streaming response
+func streamReadError(err error) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return responseFailure(fmt.Errorf("读取流式响应失败: 有一行超过 %d MB", maxStreamLine>>20))
+	}
+	return networkFailure(fmt.Errorf("读取流式响应失败: %w", err))
+}
+
+// processOpenAIStream processes the streaming response from OpenAI API.
+// In proxy-stream mode the data lines are forwarded to stdout unchanged; in
+// events-ndjson mode each chunk is written as structured events instead.
+func processOpenAIStream(responseBody io.Reader, config *Config) (string, streamStats, error) {
+	scanner := newStreamScanner(responseBody)
 	var fullResponse string
+	var stats streamStats
+	var toolCalls []toolCall
+	var refusal string // Sent instead of content when the model declines
+	var logprobs []tokenLogprob
+	var streamErr error // An error chunk that ended the stream
+	rs := newReasoningStream(config)
 	
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -402,9 +1837,15 @@ func processOpenAIStream(responseBody io.Reader) (string, error) {
 		// Skip the "data: " prefix
 		if strings.HasPrefix(line, "data: ") {
 			data := strings.TrimPrefix(line, "data: ")
+			if config.ProxyStream {
+				writeProxyLine(data)
+			}
 			
 			// Check for the end of the stream
 			if data == "[DONE]" {
+				if config.EventsNDJSON {
+					writeEvent(streamEvent{Type: "done"})
+				}
 				break
 			}
 			
@@ -412,47 +1853,157 @@ func processOpenAIStream(responseBody io.Reader) (string, error) {
 			var streamResponse struct {
 				Choices []struct {
 					Delta struct {
-						Content string `json:"content"`
+						Content          string     `json:"content"`
+						ReasoningContent string     `json:"reasoning_content"`
+						ToolCalls        []toolCall `json:"tool_calls"`
+						Refusal          string     `json:"refusal"`
 					} `json:"delta"`
+					Logprobs     choiceLogprobs `json:"logprobs"`
+					FinishReason string         `json:"finish_reason"`
 				} `json:"choices"`
+				Usage json.RawMessage `json:"usage"`
+				Error *struct {
+					Message string          `json:"message"`
+					Type    string          `json:"type"`
+					Code    json.RawMessage `json:"code"`
+				} `json:"error"`
 			}
 			
 			if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
 				continue // Skip malformed data
 			}
+
+			// Sent instead of the rest of the stream, e.g. when moderation trips
+			if e := streamResponse.Error; e != nil {
+				reason := e.Type
+				if code := strings.Trim(string(e.Code), `"`); code != "" && code != "null" {
+					reason = code
+				}
+				if reason != "" {
+					streamErr = apiFailure(fmt.Errorf("流式响应中断，API返回错误: %s (%s)", e.Message, reason))
+				} else {
+					streamErr = apiFailure(fmt.Errorf("流式响应中断，API返回错误: %s", e.Message))
+				}
+				break
+			}
+
+			// Usage only arrives when stream_options.include_usage is set
+			var usage struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			}
+			if len(streamResponse.Usage) > 0 && json.Unmarshal(streamResponse.Usage, &usage) == nil {
+				stats.InputTokens = usage.PromptTokens
+				stats.OutputTokens = usage.CompletionTokens
+			}
+			if len(streamResponse.Choices) > 0 && streamResponse.Choices[0].FinishReason != "" {
+				stats.StopReason = streamResponse.Choices[0].FinishReason
+			}
+
+			if config.EventsNDJSON {
+				if len(streamResponse.Choices) > 0 {
+					choice := streamResponse.Choices[0]
+					if choice.Delta.ReasoningContent != "" {
+						writeEvent(streamEvent{Type: "reasoning", Delta: choice.Delta.ReasoningContent})
+					}
+					if choice.Delta.Content != "" {
+						writeEvent(streamEvent{Type: "content", Delta: choice.Delta.Content})
+					}
+					for _, tc := range choice.Delta.ToolCalls {
+						index := tc.Index
+						writeEvent(streamEvent{Type: "tool_call", Index: &index, Name: tc.Function.Name, Delta: tc.Function.Arguments})
+					}
+					if choice.FinishReason != "" {
+						writeEvent(streamEvent{Type: "finish", FinishReason: choice.FinishReason})
+					}
+				}
+				writeUsageEvent(streamResponse.Usage)
+			}
 			
 			// Extract and print the content
 			if len(streamResponse.Choices) > 0 {
 				content := streamResponse.Choices[0].Delta.Content
+				if !config.rawStream() {
+					rs.Reasoning(streamResponse.Choices[0].Delta.ReasoningContent)
+					content = rs.Content(content)
+				}
 				if content != "" {
-					formattedContent := processTerminalFormatting(content)
-					fmt.Print(formattedContent)
 					fullResponse += content
+					answerOutput.Write(content)
 				}
+				refusal += streamResponse.Choices[0].Delta.Refusal
+				toolCalls = mergeToolCallDeltas(toolCalls, streamResponse.Choices[0].Delta.ToolCalls)
+				logprobs = append(logprobs, streamResponse.Choices[0].Logprobs.Content...)
+			}
+
+			// Returning closes the response body, which cancels the request
+			if rs.Stopped() {
+				stats.StoppedEarly = true
+				break
 			}
 		}
 	}
+
+	if !config.rawStream() {
+		tail := rs.Finish()
+		fullResponse += tail
+		answerOutput.Write(tail)
+	}
+	if streamErr != nil {
+		return fullResponse, stats, streamErr
+	}
+
+	// Tool calls only make sense once their arguments are complete
+	if len(toolCalls) > 0 && !config.rawStream() {
+		rendered := formatToolCalls(toolCalls)
+		emitDelta(config, rendered)
+		fullResponse += rendered
+		answerOutput.Write(rendered)
+	}
+
+	if config.Logprobs && config.Verbose && !config.rawStream() {
+		printLogprobs(logprobs)
+	}
 	
 	if err := scanner.Err(); err != nil {
-		return fullResponse, fmt.Errorf("读取流式响应失败: %w", err)
+		return fullResponse, stats, streamReadError(err)
+	}
+
+	// Same as parseOpenAIResponse: an answer with nothing in it is explained
+	if fullResponse == "" && len(toolCalls) == 0 && !config.rawStream() {
+		if refusal != "" {
+			return "", stats, apiFailure(fmt.Errorf("模型拒绝回答: %s", refusal))
+		}
+		if stats.StopReason == "content_filter" || stats.StopReason == "length" {
+			return "", stats, emptyAnswerError(stats.StopReason)
+		}
 	}
 	
-	return fullResponse, nil
+	return fullResponse, stats, nil
 }
 
-// processAnthropicStream processes the streaming response from Anthropic API
-func processAnthropicStream(responseBody io.Reader) (string, error) {
-	scanner := bufio.NewScanner(responseBody)
+// processAnthropicStream processes the streaming response from Anthropic API.
+// In proxy-stream mode the events are re-framed as OpenAI chunks on stdout;
+// in events-ndjson mode they are written as structured events. The input
+// tokens come from message_start; message_delta carries the stop reason and
+// the final output token count, and message_stop ends the stream.
+func processAnthropicStream(responseBody io.Reader, config *Config) (string, streamStats, error) {
+	scanner := newStreamScanner(responseBody)
 	var fullResponse string
+	var stats streamStats
+	rs := newReasoningStream(config)
 	
 	for scanner.Scan() {
 		line := scanner.Text()
-		if line == "" || !strings.HasPrefix(line, "data: ") {
+		// Each data line follows an "event:" line with the type, which the
+		// JSON repeats, so only the data lines are read
+		if line == "" || strings.HasPrefix(line, "event:") {
+			continue
+		}
+		data, found := strings.CutPrefix(line, "data: ")
+		if !found {
 			continue
 		}
-		
-		// Skip the "data: " prefix
-		data := strings.TrimPrefix(line, "data: ")
 		
 		// Check for the end of the stream
 		if data == "[DONE]" {
@@ -463,25 +2014,133 @@ func processAnthropicStream(responseBody io.Reader) (string, error) {
 		var streamResponse struct {
 			Type    string `json:"type"`
 			Delta   struct {
-				Text string `json:"text"`
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
 			} `json:"delta"`
+			Index   int `json:"index"`
+			Message struct {
+				Usage json.RawMessage `json:"usage"`
+			} `json:"message"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Usage json.RawMessage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
 		}
 		
 		if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
 			continue // Skip malformed data
 		}
+
+		var usage anthropicUsage
+		switch streamResponse.Type {
+		case "message_start":
+			if json.Unmarshal(streamResponse.Message.Usage, &usage) == nil {
+				stats.InputTokens = usage.InputTokens
+				stats.OutputTokens = usage.OutputTokens
+				stats.CacheCreationTokens = usage.CacheCreationInputTokens
+				stats.CacheReadTokens = usage.CacheReadInputTokens
+			}
+		case "message_delta":
+			if streamResponse.Delta.StopReason != "" {
+				stats.StopReason = streamResponse.Delta.StopReason
+			}
+			// output_tokens is cumulative, so the last value is the total.
+			// Newer API versions repeat the input tokens here as well.
+			if json.Unmarshal(streamResponse.Usage, &usage) == nil {
+				if usage.OutputTokens > 0 {
+					stats.OutputTokens = usage.OutputTokens
+				}
+				if usage.InputTokens > 0 {
+					stats.InputTokens = usage.InputTokens
+				}
+			}
+		case "error":
+			// Sent instead of the rest of the stream, e.g. when overloaded
+			return fullResponse, stats, apiFailure(fmt.Errorf("Anthropic 返回错误: %s (%s)", streamResponse.Error.Message, streamResponse.Error.Type))
+		}
+		// ping, content_block_stop and message_stop carry no usage
+
+		if config.EventsNDJSON {
+			switch streamResponse.Type {
+			case "message_start":
+				writeUsageEvent(streamResponse.Message.Usage)
+			case "content_block_start":
+				if streamResponse.ContentBlock.Type == "tool_use" {
+					index := streamResponse.Index
+					writeEvent(streamEvent{Type: "tool_call", Index: &index, Name: streamResponse.ContentBlock.Name})
+				}
+			case "content_block_delta":
+				switch {
+				case streamResponse.Delta.Thinking != "":
+					writeEvent(streamEvent{Type: "reasoning", Delta: streamResponse.Delta.Thinking})
+				case streamResponse.Delta.Text != "":
+					writeEvent(streamEvent{Type: "content", Delta: streamResponse.Delta.Text})
+				case streamResponse.Delta.PartialJSON != "":
+					index := streamResponse.Index
+					writeEvent(streamEvent{Type: "tool_call", Index: &index, Delta: streamResponse.Delta.PartialJSON})
+				}
+			case "message_delta":
+				if streamResponse.Delta.StopReason != "" {
+					writeEvent(streamEvent{Type: "finish", FinishReason: streamResponse.Delta.StopReason})
+				}
+				writeUsageEvent(streamResponse.Usage)
+			case "message_stop":
+				writeEvent(streamEvent{Type: "done"})
+			}
+		}
 		
 		// Extract and print the content
+		if streamResponse.Type == "content_block_delta" && streamResponse.Delta.Thinking != "" && !config.rawStream() {
+			rs.Reasoning(streamResponse.Delta.Thinking)
+		}
+
 		if streamResponse.Type == "content_block_delta" && streamResponse.Delta.Text != "" {
-			formattedContent := processTerminalFormatting(streamResponse.Delta.Text)
-			fmt.Print(formattedContent)
-			fullResponse += streamResponse.Delta.Text
+			text := streamResponse.Delta.Text
+			if config.ProxyStream {
+				writeProxyChunk(config.Model, text, "")
+			} else if !config.EventsNDJSON {
+				text = rs.Content(text)
+			}
+			fullResponse += text
+			answerOutput.Write(text)
+		}
+
+		// Returning closes the response body, which cancels the request
+		if rs.Stopped() {
+			stats.StoppedEarly = true
+			break
+		}
+
+		if config.ProxyStream && streamResponse.Type == "message_delta" {
+			if finish := anthropicFinishReason(streamResponse.Delta.StopReason); finish != "" {
+				writeProxyChunk(config.Model, "", finish)
+			}
+		}
+
+		if streamResponse.Type == "message_stop" {
+			break
 		}
 	}
+
+	if config.ProxyStream {
+		writeProxyDone()
+	} else if !config.EventsNDJSON {
+		tail := rs.Finish()
+		fullResponse += tail
+		answerOutput.Write(tail)
+	}
 	
 	if err := scanner.Err(); err != nil {
-		return fullResponse, fmt.Errorf("读取流式响应失败: %w", err)
+		return fullResponse, stats, streamReadError(err)
 	}
 	
-	return fullResponse, nil
+	return fullResponse, stats, nil
 }
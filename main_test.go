@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// anthropicMaxTokensStream is a recorded Anthropic stream of an answer that
+// ran into max_tokens
+const anthropicMaxTokensStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","model":"claude-test","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", world"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"max_tokens","stop_sequence":null},"usage":{"output_tokens":15}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestProcessAnthropicStream(t *testing.T) {
+	config := newConfig()
+	config.Provider = "anthropic"
+	var written strings.Builder
+	config.OnDelta = func(text string) { written.WriteString(text) }
+
+	answer, stats, err := processAnthropicStream(strings.NewReader(anthropicMaxTokensStream), config)
+	if err != nil {
+		t.Fatalf("processAnthropicStream: %v", err)
+	}
+	if answer != "Hello, world" {
+		t.Errorf("answer = %q, want %q", answer, "Hello, world")
+	}
+	if got := written.String(); got != "Hello, world" {
+		t.Errorf("written = %q, want %q", got, "Hello, world")
+	}
+	if stats.InputTokens != 25 || stats.OutputTokens != 15 {
+		t.Errorf("usage = %d in / %d out, want 25 in / 15 out", stats.InputTokens, stats.OutputTokens)
+	}
+	if stats.StopReason != "max_tokens" || !stats.truncated() {
+		t.Errorf("stop reason = %q, truncated = %v; want max_tokens, true", stats.StopReason, stats.truncated())
+	}
+
+	// The user is told that the answer was cut off
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	reportStreamStats(config, stats)
+	os.Stderr = stderr
+	w.Close()
+	report, _ := io.ReadAll(r)
+	if !strings.Contains(string(report), "已被截断") {
+		t.Errorf("report = %q, want the truncation warning", report)
+	}
+}
+
+// TestLoadConfigBOMAndCRLF loads config files as Windows editors save them:
+// with a BOM in front and CRLF line endings
+func TestLoadConfigBOMAndCRLF(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"key=value", "wen.conf", "model=gpt-test\r\napi_key=sk-test\r\n# comment\r\n\r\n[profile fast]\r\nmodel=gpt-fast\r\nmax_tokens=50\r\n"},
+		{"JSON", "wen.json", "{\r\n  \"model\": \"gpt-test\",\r\n  \"api_key\": \"sk-test\",\r\n  \"profiles\": {\"fast\": {\"model\": \"gpt-fast\", \"max_tokens\": 50}}\r\n}\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte("\ufeff"+tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := loadConfig(path, "")
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			// The key right after the BOM is recognised
+			if config.Model != "gpt-test" || config.APIKey != "sk-test" {
+				t.Errorf("model = %q, api_key = %q; want gpt-test, sk-test", config.Model, config.APIKey)
+			}
+
+			config, err = loadConfig(path, "fast")
+			if err != nil {
+				t.Fatalf("loadConfig with profile: %v", err)
+			}
+			if config.Model != "gpt-fast" || config.MaxTokens != 50 || config.APIKey != "sk-test" {
+				t.Errorf("profile: model = %q, max_tokens = %d, api_key = %q; want gpt-fast, 50, sk-test", config.Model, config.MaxTokens, config.APIKey)
+			}
+		})
+	}
+}
+
+func TestApplyOptionsTopLogprobs(t *testing.T) {
+	for _, n := range []int{-1, 21} {
+		if err := applyOptions(newConfig(), &Options{TopLogprobs: n}); err == nil {
+			t.Errorf("--top-logprobs %d was accepted", n)
+		}
+	}
+	config := newConfig()
+	if err := applyOptions(config, &Options{TopLogprobs: 20}); err != nil {
+		t.Fatalf("--top-logprobs 20: %v", err)
+	}
+	if !config.Logprobs || config.TopLogprobs != 20 {
+		t.Errorf("logprobs = %v, top_logprobs = %d; want true, 20", config.Logprobs, config.TopLogprobs)
+	}
+}
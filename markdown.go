@@ -0,0 +1,197 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdFence      = regexp.MustCompile("^\\s*(```|~~~)")
+	mdHeading    = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	mdBullet     = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	mdQuote      = regexp.MustCompile(`^\s{0,3}>\s?`)
+	mdRule       = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+	mdBold       = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	mdItalic     = regexp.MustCompile(`\*([^*\s][^*\n]*)\*`)
+	mdInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	mdLink       = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+)
+
+// stripMarkdownLine removes Markdown syntax from a line outside code blocks.
+// It only touches Markdown markers, so color tags like <red> survive.
+func stripMarkdownLine(line string) string {
+	if mdRule.MatchString(line) {
+		return ""
+	}
+	line = mdHeading.ReplaceAllString(line, "")
+	line = mdQuote.ReplaceAllString(line, "")
+	line = mdBullet.ReplaceAllString(line, "${1}• ")
+	line = mdInlineCode.ReplaceAllString(line, "$1")
+	line = mdBold.ReplaceAllString(line, "$1$2")
+	line = mdItalic.ReplaceAllString(line, "$1")
+	line = mdLink.ReplaceAllString(line, "$1 ($2)")
+	return line
+}
+
+// markdownStripper removes common Markdown syntax line by line. Code fences
+// are dropped but the code inside them is kept as is.
+type markdownStripper struct {
+	partial string // incomplete last line of a stream
+	inFence bool
+}
+
+// stripMarkdown removes Markdown syntax from a complete answer
+func stripMarkdown(text string) string {
+	m := &markdownStripper{}
+	return m.Feed(text) + m.Flush()
+}
+
+// Feed returns the converted text of all lines completed so far
+func (m *markdownStripper) Feed(text string) string {
+	m.partial += text
+	end := strings.LastIndex(m.partial, "\n")
+	if end < 0 {
+		return ""
+	}
+	complete := m.partial[:end+1]
+	m.partial = m.partial[end+1:]
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(complete, "\n") {
+		if line != "" {
+			b.WriteString(m.line(line))
+		}
+	}
+	return b.String()
+}
+
+// Flush converts the last, unterminated line
+func (m *markdownStripper) Flush() string {
+	line := m.partial
+	m.partial = ""
+	if line == "" {
+		return ""
+	}
+	return m.line(line)
+}
+
+// line converts one line, including its newline if it has one
+func (m *markdownStripper) line(line string) string {
+	body := strings.TrimSuffix(line, "\n")
+	if mdFence.MatchString(body) {
+		m.inFence = !m.inFence
+		return ""
+	}
+	if m.inFence {
+		return line
+	}
+	return stripMarkdownLine(body) + line[len(body):]
+}
+
+// Terminal styles of render_markdown. They turn off only their own attribute,
+// so color tags around them stay in effect.
+const (
+	mdHeadingStyle = "\033[1;4m"
+	mdHeadingEnd   = "\033[22;24m"
+	mdBoldStyle    = "\033[1m"
+	mdBoldEnd      = "\033[22m"
+	mdItalicStyle  = "\033[3m"
+	mdItalicEnd    = "\033[23m"
+	mdCodeStyle    = "\033[2m"
+	mdCodeEnd      = "\033[22m"
+)
+
+// renderMarkdownInline styles bold, italic and inline code. The text of code
+// spans is left as it is.
+func renderMarkdownInline(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mdInlineCode.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(renderMarkdownEmphasis(line[last:loc[0]]))
+		b.WriteString(mdCodeStyle + line[loc[2]:loc[3]] + mdCodeEnd)
+		last = loc[1]
+	}
+	b.WriteString(renderMarkdownEmphasis(line[last:]))
+	return b.String()
+}
+
+// renderMarkdownEmphasis styles bold and italic text
+func renderMarkdownEmphasis(text string) string {
+	text = mdBold.ReplaceAllString(text, mdBoldStyle+"$1$2"+mdBoldEnd)
+	return mdItalic.ReplaceAllString(text, mdItalicStyle+"$1"+mdItalicEnd)
+}
+
+// renderMarkdownLine converts the Markdown of a line outside code blocks to
+// terminal styles: headings become bold and underlined, and bullets "•"
+func renderMarkdownLine(line string) string {
+	if loc := mdHeading.FindStringIndex(line); loc != nil {
+		return mdHeadingStyle + renderMarkdownInline(line[loc[1]:]) + mdHeadingEnd
+	}
+	line = mdBullet.ReplaceAllString(line, "${1}• ")
+	return renderMarkdownInline(line)
+}
+
+// markdownRenderer converts Markdown to terminal styles for render_markdown.
+// Lines inside code blocks, and the fences, are left as they are.
+type markdownRenderer struct {
+	inFence bool
+}
+
+// render converts text made of complete lines
+func (m *markdownRenderer) render(text string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		if mdFence.MatchString(body) {
+			m.inFence = !m.inFence
+		} else if !m.inFence {
+			line = renderMarkdownLine(body) + line[len(body):]
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// codeBlockStopper ends the answer after the line that closes its first code
+// block, for --stop-after-code
+type codeBlockStopper struct {
+	line    string // Start of the current line
+	inFence bool
+	stopped bool
+}
+
+// Feed returns text up to and including the line closing the first code
+// block, and nothing once that line has been seen
+func (c *codeBlockStopper) Feed(text string) string {
+	if c.stopped {
+		return ""
+	}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\n' {
+			continue
+		}
+		line := c.line + text[start:i]
+		c.line = ""
+		start = i + 1
+		if mdFence.MatchString(line) {
+			if c.inFence {
+				c.stopped = true
+				return text[:i+1]
+			}
+			c.inFence = true
+		}
+	}
+	c.line += text[start:]
+	return text
+}
+
+// Flush returns nothing; the stopper never holds text back
+func (c *codeBlockStopper) Flush() string {
+	return ""
+}
+
+// Stopped reports whether the first code block has been completed
+func (c *codeBlockStopper) Stopped() bool {
+	return c.stopped
+}
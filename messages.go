@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message is a single chat message sent to the model
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// allowedRoles lists the message roles each request format accepts. The
+// Anthropic and Gemini APIs take the system prompt separately, not as a
+// message.
+var allowedRoles = map[string][]string{
+	"openai":    {"system", "developer", "user", "assistant"},
+	"anthropic": {"user", "assistant"},
+	"gemini":    {"user", "assistant"},
+	"ollama":    {"system", "user", "assistant"},
+}
+
+// rolesFor returns the roles accepted by the provider's request format
+func rolesFor(provider string) []string {
+	if roles, ok := allowedRoles[provider]; ok {
+		return roles
+	}
+	return allowedRoles["openai"]
+}
+
+// loadMessages reads a JSON array of {role, content} objects and checks the
+// roles against what the provider accepts
+func loadMessages(path string, provider string) ([]Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取消息文件失败: %w", err)
+	}
+
+	var messages []Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("解析消息文件失败: %w", err)
+	}
+
+	roles := rolesFor(provider)
+	for i, m := range messages {
+		valid := false
+		for _, r := range roles {
+			if m.Role == r {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("消息文件第 %d 条消息的角色 %q 无效，%s 支持: %s", i+1, m.Role, provider, strings.Join(roles, ", "))
+		}
+	}
+
+	return messages, nil
+}
+
+// conversation returns the messages to send: the preset messages followed by
+// the question as a user message, if there is one
+func conversation(question string, config *Config) []Message {
+	messages := append([]Message{}, config.Messages...)
+	if question != "" {
+		messages = append(messages, Message{Role: "user", Content: question})
+	}
+	return messages
+}
+
+// hasSystemMessage reports whether the messages start with their own system prompt
+func hasSystemMessage(messages []Message) bool {
+	return len(messages) > 0 && (messages[0].Role == "system" || messages[0].Role == "developer")
+}
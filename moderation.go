@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultModerationModel is the OpenAI model used with moderate_input
+const defaultModerationModel = "omni-moderation-latest"
+
+// moderationURL returns the moderation endpoint next to the configured chat
+// completions endpoint, unless moderation_url is set
+func moderationURL(config *Config) string {
+	if config.ModerationURL != "" {
+		return config.ModerationURL
+	}
+	if base, ok := strings.CutSuffix(config.APIURL, "/chat/completions"); ok {
+		return base + "/moderations"
+	}
+	return "https://api.openai.com/v1/moderations"
+}
+
+// moderateInput checks the question and preset user messages with the OpenAI
+// moderation endpoint and returns an error naming the categories when any of
+// them is flagged. The check fails closed: if it cannot be done, nothing is
+// sent to the model.
+func moderateInput(question string, config *Config) error {
+	if config.Provider != "openai" && config.ModerationURL == "" {
+		return fmt.Errorf("moderate_input 使用 OpenAI 的内容审核接口，%s 需要同时设置 moderation_url", config.Provider)
+	}
+
+	var inputs []string
+	for _, m := range config.Messages {
+		if m.Role == "user" && m.Content != "" {
+			inputs = append(inputs, m.Content)
+		}
+	}
+	if question != "" {
+		inputs = append(inputs, question)
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"model": defaultModerationModel,
+		"input": inputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", moderationURL(config), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return fmt.Errorf("创建内容审核请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.APIKey)
+
+	resp, err := httpClient(config).Do(req)
+	if err != nil {
+		return fmt.Errorf("内容审核请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取内容审核结果失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &apiError{StatusCode: resp.StatusCode, Body: body}
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("解析内容审核结果失败: %w", err)
+	}
+
+	blocked := false
+	categories := map[string]bool{}
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		blocked = true
+		for category, hit := range r.Categories {
+			if hit {
+				categories[category] = true
+			}
+		}
+	}
+	if !blocked {
+		return nil
+	}
+
+	names := make([]string, 0, len(categories))
+	for category := range categories {
+		names = append(names, category)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("问题未通过内容审核: %s", strings.Join(names, ", "))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ollamaResponse is the /api/chat response, and each line of its stream
+type ollamaResponse struct {
+	Message struct {
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// stats returns the token usage and stop reason of a final response
+func (r *ollamaResponse) stats() streamStats {
+	return streamStats{
+		InputTokens:  r.PromptEvalCount,
+		OutputTokens: r.EvalCount,
+		StopReason:   r.DoneReason,
+	}
+}
+
+// createOllamaRequest creates the request body for Ollama's /api/chat. The
+// messages have the OpenAI shape; sampling settings go in "options".
+func createOllamaRequest(question, systemPrompt string, config *Config, stream bool) ([]byte, error) {
+	if len(config.Images) > 0 {
+		return nil, fmt.Errorf("--image 目前只支持 OpenAI 和 Anthropic")
+	}
+	messages := conversation(question, config)
+	if !hasSystemMessage(messages) && systemPrompt != "" {
+		messages = append([]Message{{Role: "system", Content: systemPrompt}}, messages...)
+	}
+	requestBody := map[string]interface{}{
+		"model":    config.Model,
+		"messages": messages,
+		// Ollama streams unless told otherwise, so "stream" is always sent
+		"stream": stream,
+	}
+	options := map[string]interface{}{}
+	if config.Temperature != nil {
+		options["temperature"] = *config.Temperature
+	}
+	if config.MaxTokens > 0 {
+		options["num_predict"] = config.MaxTokens
+	}
+	if len(options) > 0 {
+		requestBody["options"] = options
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	printRequestDebug(config, "Ollama", systemPrompt, question)
+
+	return jsonData, nil
+}
+
+// parseOllamaResponse parses a non-streamed response from Ollama
+func parseOllamaResponse(responseBody []byte) (string, error) {
+	var response ollamaResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", responseFailure(fmt.Errorf("解析响应失败: %w", err))
+	}
+	if response.Error != "" {
+		return "", apiFailure(fmt.Errorf("Ollama 返回错误: %s", response.Error))
+	}
+	return wrapReasoning(response.Message.Thinking, response.Message.Content), nil
+}
+
+// processOllamaStream processes Ollama's streaming response, which is
+// newline-delimited JSON rather than SSE. The last object has done:true and
+// the token counts.
+func processOllamaStream(responseBody io.Reader, config *Config) (string, streamStats, error) {
+	scanner := newStreamScanner(responseBody)
+	var fullResponse string
+	var stats streamStats
+	rs := newReasoningStream(config)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue // Skip malformed data
+		}
+		if chunk.Error != "" {
+			return fullResponse, stats, apiFailure(fmt.Errorf("Ollama 返回错误: %s", chunk.Error))
+		}
+		finish := ""
+		if chunk.Done {
+			stats = chunk.stats()
+			finish = chunk.DoneReason
+			if finish == "" {
+				finish = "stop"
+			}
+		}
+
+		if config.EventsNDJSON {
+			if chunk.Message.Thinking != "" {
+				writeEvent(streamEvent{Type: "reasoning", Delta: chunk.Message.Thinking})
+			}
+			if chunk.Message.Content != "" {
+				writeEvent(streamEvent{Type: "content", Delta: chunk.Message.Content})
+			}
+			if finish != "" {
+				writeEvent(streamEvent{Type: "finish", FinishReason: finish})
+			}
+		}
+
+		text := chunk.Message.Content
+		if chunk.Message.Thinking != "" && !config.rawStream() {
+			rs.Reasoning(chunk.Message.Thinking)
+		}
+		if text != "" || (config.ProxyStream && finish != "") {
+			if config.ProxyStream {
+				writeProxyChunk(config.Model, text, finish)
+			} else if !config.EventsNDJSON {
+				text = rs.Content(text)
+			}
+			fullResponse += text
+			answerOutput.Write(text)
+		}
+
+		// Returning closes the response body, which cancels the request
+		if rs.Stopped() {
+			stats.StoppedEarly = true
+			break
+		}
+		if chunk.Done {
+			break
+		}
+	}
+
+	if config.ProxyStream {
+		writeProxyDone()
+	} else if config.EventsNDJSON {
+		writeEvent(streamEvent{Type: "done"})
+	} else {
+		tail := rs.Finish()
+		fullResponse += tail
+		answerOutput.Write(tail)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fullResponse, stats, streamReadError(err)
+	}
+
+	return fullResponse, stats, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// outputSyncInterval bounds how much of a streamed answer a crash can lose
+const outputSyncInterval = time.Second
+
+// answerWriter saves the answer to a file as it is generated. Every delta is
+// written straight through to the file, so an interrupted run keeps what was
+// received so far, and the file is synced to disk periodically. Color tags
+// and escape sequences are left out, so the file holds plain text.
+type answerWriter struct {
+	file     *os.File
+	lastSync time.Time
+	size     int
+	endsLine bool
+	tag      string // Start of a color tag held back until it is complete
+}
+
+// answerOutput is the file given with --output (--out) or --output-append, if any
+var answerOutput *answerWriter
+
+// openAnswerWriter opens the output file. In append mode a separator header
+// with the time and the question is written before the new answer.
+func openAnswerWriter(path string, appendMode bool, question string) (*answerWriter, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("打开输出文件失败: %w", err)
+	}
+	w := &answerWriter{file: file, lastSync: time.Now()}
+
+	if appendMode {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("打开输出文件失败: %w", err)
+		}
+		header := fmt.Sprintf("===== %s =====\n问题: %s\n\n", time.Now().Format("2006-01-02 15:04:05"), question)
+		if info.Size() > 0 {
+			header = "\n" + header
+		}
+		if _, err := file.WriteString(header); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("写入输出文件失败: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+// Write appends a piece of the answer. Errors are reported once on stderr and
+// do not interrupt the answer on the terminal.
+func (w *answerWriter) Write(text string) {
+	if w == nil || w.file == nil || text == "" {
+		return
+	}
+	text = w.tag + text
+	w.tag = ""
+	if keep := partialColorTag(text); keep > 0 {
+		w.tag = text[len(text)-keep:]
+		text = text[:len(text)-keep]
+	}
+	w.write(plainText(text))
+}
+
+// write appends text to the file
+func (w *answerWriter) write(text string) {
+	if text == "" {
+		return
+	}
+	if _, err := w.file.WriteString(text); err != nil {
+		fmt.Fprintf(os.Stderr, "写入输出文件失败: %v\n", err)
+		w.file.Close()
+		w.file = nil
+		return
+	}
+	w.size += len(text)
+	w.endsLine = strings.HasSuffix(text, "\n")
+
+	if time.Since(w.lastSync) >= outputSyncInterval {
+		w.file.Sync()
+		w.lastSync = time.Now()
+	}
+}
+
+// Close terminates the answer with a newline, syncs and closes the file
+func (w *answerWriter) Close() {
+	if w == nil || w.file == nil {
+		return
+	}
+	w.write(w.tag) // Not a tag after all
+	w.tag = ""
+	if w.file == nil {
+		return
+	}
+	if w.size > 0 && !w.endsLine {
+		w.file.WriteString("\n")
+	}
+	w.file.Sync()
+	w.file.Close()
+	w.file = nil
+}
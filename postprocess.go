@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// postStep is one transform of the postprocess pipeline, written as name or
+// name(param), e.g. strip-prefix(/^Sure[,!]/)
+type postStep struct {
+	Name  string
+	Param string
+}
+
+// postTransforms lists the built-in transforms and whether they take a
+// parameter: 0 none, 1 optional, 2 required. The order is the one used for
+// transforms enabled by their own options rather than by postprocess.
+var postTransforms = []struct {
+	name  string
+	param int
+}{
+	{"stop-after-code", 0},
+	{"strip-thinking", 0},
+	{"strip-prefix", 1},
+	{"strip-citations", 1},
+	{"strip-markdown", 0},
+	{"highlight", 2},
+}
+
+// splitPipeline splits a postprocess value at the commas outside parameters
+func splitPipeline(value string) []string {
+	var specs []string
+	depth, start := 0, 0
+	for i, r := range value {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				specs = append(specs, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	specs = append(specs, value[start:])
+
+	var steps []string
+	for _, s := range specs {
+		if s = strings.TrimSpace(s); s != "" {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// parsePostStep parses and checks one pipeline entry
+func parsePostStep(spec string) (postStep, error) {
+	step := postStep{Name: spec}
+	if open := strings.Index(spec, "("); open >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return step, fmt.Errorf("postprocess 中的 %q 缺少右括号", spec)
+		}
+		step.Name = strings.TrimSpace(spec[:open])
+		step.Param = spec[open+1 : len(spec)-1]
+	}
+
+	param := -1
+	for _, t := range postTransforms {
+		if t.name == step.Name {
+			param = t.param
+		}
+	}
+	switch {
+	case param < 0:
+		return step, fmt.Errorf("postprocess 中有未知的处理步骤 %q", step.Name)
+	case param == 0 && step.Param != "":
+		return step, fmt.Errorf("postprocess 中的 %s 不接受参数", step.Name)
+	case param == 2 && step.Param == "":
+		return step, fmt.Errorf("postprocess 中的 %s 需要参数，如 %s(文字)", step.Name, step.Name)
+	}
+
+	var err error
+	if step.Param != "" {
+		switch step.Name {
+		case "strip-prefix":
+			_, err = compilePrefix(step.Param)
+		case "strip-citations":
+			_, err = compileCitationPatterns([]string{step.Param})
+		case "highlight":
+			_, err = compileHighlight([]string{step.Param})
+		}
+	}
+	return step, err
+}
+
+// parsePipeline parses a postprocess config value
+func parsePipeline(value string) ([]postStep, error) {
+	var steps []postStep
+	for _, spec := range splitPipeline(value) {
+		step, err := parsePostStep(spec)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// pipelineSteps returns the transforms to apply: the postprocess pipeline,
+// with the transforms enabled by their own options (--strip-markdown,
+// strip_prefixes, ...) added in the default order unless the pipeline
+// already names them. stop-after-code always goes first, since it decides
+// when the answer ends.
+func pipelineSteps(config *Config) []postStep {
+	named := map[string]bool{}
+	for _, s := range config.PostProcess {
+		named[s.Name] = true
+	}
+	enabled := map[string]bool{
+		"stop-after-code": config.StopAfterCode,
+		"strip-prefix":    len(config.StripPrefixes) > 0,
+		"strip-citations": config.StripCitations,
+		"strip-markdown":  config.StripMarkdown,
+	}
+
+	var steps []postStep
+	if enabled["stop-after-code"] && !named["stop-after-code"] {
+		steps = append(steps, postStep{Name: "stop-after-code"})
+	}
+	steps = append(steps, config.PostProcess...)
+	for _, t := range postTransforms[1:] { // stop-after-code was handled above
+		if enabled[t.name] && !named[t.name] {
+			steps = append(steps, postStep{Name: t.name})
+		}
+	}
+	return steps
+}
+
+// filter returns the stream filter of a step, or nil for steps that are not
+// applied to the answer text itself
+func (s postStep) filter(config *Config) streamFilter {
+	switch s.Name {
+	case "stop-after-code":
+		return &codeBlockStopper{}
+	case "strip-thinking":
+		return &thinkStripper{}
+	case "strip-prefix":
+		patterns := config.StripPrefixes
+		if s.Param != "" {
+			patterns = []string{s.Param}
+		}
+		if len(patterns) == 0 {
+			return nil
+		}
+		return &prefixStripper{patterns: patterns}
+	case "strip-citations":
+		if s.Param != "" {
+			c := *config
+			c.CitationPatterns = []string{s.Param}
+			return newCitationStripper(&c)
+		}
+		return newCitationStripper(config)
+	case "strip-markdown":
+		return &markdownStripper{}
+	}
+	return nil // highlight works on the displayed text, see renderText
+}
+
+// highlightPatterns returns the --highlight patterns and the parameters of
+// highlight steps in the pipeline
+func highlightPatterns(config *Config) []string {
+	patterns := append([]string(nil), config.Highlight...)
+	for _, s := range config.PostProcess {
+		if s.Name == "highlight" {
+			patterns = append(patterns, s.Param)
+		}
+	}
+	return patterns
+}
+
+// thinkStripper removes <think>...</think> blocks from anywhere in the
+// answer. A block at the very start is split off as reasoning before the
+// filters run; this catches the ones some models emit further on.
+type thinkStripper struct {
+	pending string // Text that may be the start of a tag
+	inThink bool
+}
+
+// Feed returns the text outside think blocks
+func (t *thinkStripper) Feed(text string) string {
+	text = t.pending + text
+	t.pending = ""
+	var b strings.Builder
+	for text != "" {
+		tag := thinkOpenTag
+		if t.inThink {
+			tag = thinkCloseTag
+		}
+		if i := strings.Index(text, tag); i >= 0 {
+			if !t.inThink {
+				b.WriteString(text[:i])
+			}
+			text = text[i+len(tag):]
+			t.inThink = !t.inThink
+			continue
+		}
+		keep := partialTag(text, tag)
+		if !t.inThink {
+			b.WriteString(text[:len(text)-keep])
+		}
+		t.pending = text[len(text)-keep:]
+		break
+	}
+	return b.String()
+}
+
+// Flush returns held-back text; an unterminated block is dropped
+func (t *thinkStripper) Flush() string {
+	text := t.pending
+	t.pending = ""
+	if t.inThink {
+		return ""
+	}
+	return text
+}
+
+// partialTag returns the length of the longest end of text that is the
+// start of tag
+func partialTag(text, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
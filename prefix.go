@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxPrefixBuffer is how much streamed text is held back, at most, while
+// waiting for the first line to decide whether it starts with filler
+const maxPrefixBuffer = 256
+
+// compilePrefix turns a strip_prefixes entry into an anchored, case-insensitive
+// regexp. Entries written as /regex/ are regular expressions; anything else is
+// matched literally.
+func compilePrefix(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	}
+	re, err := regexp.Compile(`(?i)^(?:` + expr + `)`)
+	if err != nil {
+		return nil, fmt.Errorf("strip_prefixes 中的正则表达式 %q 无效: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// stripPrefixes removes the configured leading phrases from an answer, in the
+// order they are configured, along with the whitespace that follows them
+func stripPrefixes(answer string, patterns []string) string {
+	for _, p := range patterns {
+		re, err := compilePrefix(p)
+		if err != nil {
+			continue // Rejected when the config was loaded
+		}
+		if loc := re.FindStringIndex(answer); loc != nil && loc[1] > 0 {
+			answer = strings.TrimLeft(answer[loc[1]:], " \t\r\n")
+		}
+	}
+	return answer
+}
+
+// prefixStripper applies stripPrefixes to the start of a stream. It buffers
+// text until the first line is complete, then passes everything through.
+type prefixStripper struct {
+	patterns []string
+	buffer   string
+	done     bool
+}
+
+// Feed returns the text that can be displayed now
+func (p *prefixStripper) Feed(text string) string {
+	if p.done {
+		return text
+	}
+	p.buffer += text
+	if !strings.Contains(p.buffer, "\n") && len(p.buffer) < maxPrefixBuffer {
+		return ""
+	}
+	return p.Flush()
+}
+
+// Flush releases the buffered text, stripped, at the end of the stream
+func (p *prefixStripper) Flush() string {
+	if p.done {
+		return ""
+	}
+	p.done = true
+	text := stripPrefixes(p.buffer, p.patterns)
+	p.buffer = ""
+	return text
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"syscall"
+)
+
+// stopProfiling flushes and closes the profiles started by startProfiling.
+// It does nothing when no profile is being written and is safe to call more
+// than once.
+var stopProfiling = func() {}
+
+// startProfiling starts the CPU profile and execution trace requested with
+// --cpuprofile and --trace. Profiles are also flushed when wen is
+// interrupted, so a slow run can be stopped with Ctrl-C and still analyzed.
+func startProfiling(opts *Options) error {
+	var stops []func()
+	stopAll := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if opts.CPUProfile != "" {
+		file, err := os.Create(opts.CPUProfile)
+		if err != nil {
+			return fmt.Errorf("创建 CPU 性能分析文件失败: %w", err)
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fmt.Errorf("启动 CPU 性能分析失败: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			file.Close()
+		})
+	}
+
+	if opts.Trace != "" {
+		file, err := os.Create(opts.Trace)
+		if err != nil {
+			stopAll()
+			return fmt.Errorf("创建执行跟踪文件失败: %w", err)
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			stopAll()
+			return fmt.Errorf("启动执行跟踪失败: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			file.Close()
+		})
+	}
+
+	if len(stops) == 0 {
+		return nil
+	}
+
+	var once sync.Once
+	stopProfiling = func() { once.Do(stopAll) }
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		stopProfiling()
+		os.Exit(130)
+	}()
+	return nil
+}
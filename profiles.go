@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// profileHeader matches the "[profile name]" line that starts a section
+var profileHeader = regexp.MustCompile(`^\[\s*profile\s+([^\]\s]+)\s*\]$`)
+
+// configLines returns the settings of a config file as key=value lines: the
+// top-level lines, followed by the lines of the [profile name] section when
+// profile is set, so the profile overrides the top level. A profile that
+// sets its own provider does not inherit api_key and api_url, which belong
+// to the provider of the top level. Files starting with "{", or asJSON ones,
+// are JSON configs; see jsonConfigLines.
+func configLines(r io.Reader, profile string, asJSON bool) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	// Files saved on Windows may start with a BOM and use CRLF line endings
+	text := strings.TrimPrefix(string(data), "\ufeff")
+
+	var top []string
+	var sections configSections
+	if asJSON || strings.HasPrefix(strings.TrimSpace(text), "{") {
+		top, sections, err = jsonConfigLines([]byte(text))
+	} else {
+		top, sections, err = iniConfigLines(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == "" {
+		return top, nil
+	}
+	selected, found := sections.lines[profile]
+	if !found {
+		if len(sections.names) == 0 {
+			return nil, fmt.Errorf("配置文件中没有 profile %q", profile)
+		}
+		return nil, fmt.Errorf("配置文件中没有 profile %q，已有的 profile: %s", profile, strings.Join(sections.names, ", "))
+	}
+	for _, line := range selected {
+		if configKey(line) == "provider" {
+			var inherited []string
+			for _, l := range top {
+				if key := configKey(l); key != "api_key" && key != "api_url" {
+					inherited = append(inherited, l)
+				}
+			}
+			top = inherited
+			break
+		}
+	}
+	return append(top, selected...), nil
+}
+
+// configSections holds the lines of each profile, and the profile names in
+// the order they appear
+type configSections struct {
+	lines map[string][]string
+	names []string
+}
+
+// add appends a line to the named profile
+func (s *configSections) add(name, line string) {
+	if s.lines == nil {
+		s.lines = map[string][]string{}
+	}
+	if _, found := s.lines[name]; !found {
+		s.names = append(s.names, name)
+		s.lines[name] = nil
+	}
+	if line != "" {
+		s.lines[name] = append(s.lines[name], line)
+	}
+}
+
+// iniConfigLines splits a key=value config into its top-level lines and its
+// [profile name] sections
+func iniConfigLines(text string) ([]string, configSections, error) {
+	var top []string
+	var sections configSections
+	section := "" // The top level
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") {
+			m := profileHeader.FindStringSubmatch(trimmed)
+			if m == nil {
+				return nil, sections, fmt.Errorf("无法识别的配置段 %s，应为 [profile 名称]", trimmed)
+			}
+			section = m[1]
+			sections.add(section, "")
+			continue
+		}
+		if section == "" {
+			top = append(top, line)
+		} else {
+			sections.add(section, line)
+		}
+	}
+	return top, sections, nil
+}
+
+// configKey returns the key of a key=value config line
+func configKey(line string) string {
+	key, _, _ := strings.Cut(line, "=")
+	return strings.TrimSpace(key)
+}
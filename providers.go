@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// providerInfo describes a built-in provider
+type providerInfo struct {
+	Name         string   `json:"name"`
+	DefaultURL   string   `json:"default_url"`
+	Auth         string   `json:"auth"`
+	NeedsKey     bool     `json:"requires_api_key"`
+	KeyEnv       string   `json:"key_env,omitempty"` // Standard variable holding the key, read from .env
+	Capabilities []string `json:"capabilities"`
+}
+
+// providers is the capability map of the built-in providers, in the order
+// they are listed by "wen providers". stream_usage marks the ones accepting
+// stream_options.include_usage; Azure rejects it before api-version
+// 2024-09-01.
+var providers = []providerInfo{
+	{
+		Name:         "openai",
+		DefaultURL:   "https://api.openai.com/v1/chat/completions",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     true,
+		KeyEnv:       "OPENAI_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools", "reasoning", "logprobs", "vision"},
+	},
+	{
+		Name:         "anthropic",
+		DefaultURL:   "https://api.anthropic.com/v1/messages",
+		Auth:         "x-api-key",
+		NeedsKey:     true,
+		KeyEnv:       "ANTHROPIC_API_KEY",
+		Capabilities: []string{"stream", "reasoning", "vision"},
+	},
+	{
+		Name:         "gemini",
+		DefaultURL:   "https://generativelanguage.googleapis.com/v1beta/models",
+		Auth:         "x-goog-api-key",
+		NeedsKey:     true,
+		KeyEnv:       "GEMINI_API_KEY",
+		Capabilities: []string{"stream", "reasoning", "safety"},
+	},
+	{
+		Name:         "azure",
+		DefaultURL:   "", // Every resource has its own
+		Auth:         "api-key",
+		NeedsKey:     true,
+		KeyEnv:       "AZURE_OPENAI_API_KEY",
+		Capabilities: []string{"stream", "tools", "reasoning", "logprobs", "vision"},
+	},
+	{
+		// OpenAI-compatible; deepseek-reasoner streams its chain of thought
+		// in reasoning_content, shown with show_reasoning=true
+		Name:         "deepseek",
+		DefaultURL:   "https://api.deepseek.com/v1/chat/completions",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     true,
+		KeyEnv:       "DEEPSEEK_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools", "reasoning"},
+	},
+	{
+		// DashScope's OpenAI-compatible mode, not its native input/output
+		// API; qwq and the thinking qwen3 models stream reasoning_content
+		Name:         "qwen",
+		DefaultURL:   "https://dashscope.aliyuncs.com/compatible-mode/v1/chat/completions",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     true,
+		KeyEnv:       "DASHSCOPE_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools", "reasoning", "vision"},
+	},
+	{
+		// OpenAI-compatible; an id.secret key is signed into a JWT for
+		// every request, see zhipuToken
+		Name:         "zhipu",
+		DefaultURL:   "https://open.bigmodel.cn/api/paas/v4/chat/completions",
+		Auth:         "Authorization: Bearer (JWT)",
+		NeedsKey:     true,
+		KeyEnv:       "ZHIPUAI_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools", "vision"},
+	},
+	{
+		Name:         "moonshot",
+		DefaultURL:   "https://api.moonshot.cn/v1/chat/completions",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     true,
+		KeyEnv:       "MOONSHOT_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools"},
+	},
+	{
+		// OpenAI-compatible, with models of many vendors under names like
+		// anthropic/claude-3.5-sonnet; referer and title set the
+		// HTTP-Referer and X-Title headers it ranks apps by
+		Name:         "openrouter",
+		DefaultURL:   "https://openrouter.ai/api/v1/chat/completions",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     true,
+		KeyEnv:       "OPENROUTER_API_KEY",
+		Capabilities: []string{"stream", "stream_usage", "tools", "reasoning", "vision"},
+	},
+	{
+		// Any service speaking the OpenAI protocol, such as Groq, Together,
+		// Mistral or vLLM, configured with api_url, model and,
+		// where needed, extra_headers. The key is optional for local servers.
+		Name:         "openai-compatible",
+		DefaultURL:   "",
+		Auth:         "Authorization: Bearer",
+		NeedsKey:     false,
+		Capabilities: []string{"stream", "tools"},
+	},
+	{
+		Name:         "ollama",
+		DefaultURL:   "http://localhost:11434/api/chat",
+		Auth:         "none",
+		NeedsKey:     false,
+		Capabilities: []string{"stream", "reasoning"},
+	},
+}
+
+// unknownProviderError reports a provider name that is not built in, with
+// the list of supported ones
+func unknownProviderError(name string) error {
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+	}
+	return fmt.Errorf("未知的提供商 %q，支持的提供商: %s", name, strings.Join(names, ", "))
+}
+
+// lookupProvider returns the built-in provider with the given name
+func lookupProvider(name string) (providerInfo, bool) {
+	for _, p := range providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return providerInfo{}, false
+}
+
+// switchProvider points config at another built-in provider, with its
+// default URL and the key from its standard variable, taken from the
+// environment or envFile (./.env if empty). Nothing changes when name is
+// already the configured provider.
+func switchProvider(config *Config, name, envFile string) error {
+	if name == config.Provider {
+		return nil
+	}
+	p, ok := lookupProvider(name)
+	if !ok {
+		return unknownProviderError(name)
+	}
+	config.Provider = p.Name
+	config.APIURL = p.DefaultURL
+	config.APIKey = os.Getenv(p.KeyEnv)
+	if config.APIKey == "" {
+		if envFile == "" {
+			envFile = ".env"
+		}
+		if vars, err := readEnvFile(envFile); err == nil {
+			config.APIKey = vars[p.KeyEnv]
+		}
+	}
+	if config.APIKey == "" && p.NeedsKey {
+		return fmt.Errorf("环境变量或 .env 中没有 %s", p.KeyEnv)
+	}
+	return nil
+}
+
+// needsKey reports whether requests to the provider need an API key.
+// Unknown providers are assumed to be OpenAI-compatible services that do.
+func needsKey(name string) bool {
+	if p, ok := lookupProvider(name); ok {
+		return p.NeedsKey
+	}
+	return true
+}
+
+// supports reports whether the provider has a capability
+func (p providerInfo) supports(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// runProviders implements "wen providers [--json]". It returns the process
+// exit code.
+func runProviders(args []string) int {
+	fs := flag.NewFlagSet("providers", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "以 JSON 输出")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "使用方式: ./wen providers [--json]")
+		return 1
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(providers, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+
+	rows := [][]string{{"提供商", "默认API地址", "认证方式", "需要 api_key", "功能"}}
+	for _, p := range providers {
+		needsKey := "否"
+		if p.NeedsKey {
+			needsKey = "是"
+		}
+		url := p.DefaultURL
+		if url == "" {
+			url = "(需配置 api_url)"
+		}
+		rows = append(rows, []string{p.Name, url, p.Auth, needsKey, strings.Join(p.Capabilities, ", ")})
+	}
+	printTable(rows)
+	return 0
+}
+
+// printTable prints rows as aligned columns. Unlike text/tabwriter it counts
+// CJK characters as two columns wide, so Chinese headers line up.
+func printTable(rows [][]string) {
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+		fmt.Println(b.String())
+	}
+}
+
+// displayWidth returns how many terminal columns text takes up
+func displayWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		width += runeWidth(r)
+	}
+	return width
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// In proxy-stream mode wen writes an OpenAI-compatible SSE stream to stdout
+// instead of rendering text, so it can feed tools that expect the OpenAI
+// chat.completion.chunk format regardless of the configured provider.
+
+// proxyStreamID identifies the chunks of the stream emitted by this process
+var proxyStreamID = fmt.Sprintf("chatcmpl-wen-%d", time.Now().UnixNano())
+
+// writeProxyLine forwards a raw SSE data payload unchanged
+func writeProxyLine(data string) {
+	fmt.Fprintf(os.Stdout, "data: %s\n\n", data)
+}
+
+// writeProxyChunk emits a single chat.completion.chunk event. An empty
+// finishReason is sent as null, like OpenAI does for content chunks.
+func writeProxyChunk(model, content, finishReason string) {
+	delta := map[string]string{}
+	if content != "" {
+		delta["content"] = content
+	}
+
+	var finish interface{}
+	if finishReason != "" {
+		finish = finishReason
+	}
+
+	chunk := map[string]interface{}{
+		"id":      proxyStreamID,
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			},
+		},
+	}
+
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return
+	}
+	writeProxyLine(string(data))
+}
+
+// writeProxyDone terminates the stream the way OpenAI does
+func writeProxyDone() {
+	writeProxyLine("[DONE]")
+}
+
+// anthropicFinishReason maps an Anthropic stop_reason to OpenAI's finish_reason
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "":
+		return ""
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
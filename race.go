@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// raceResult is the outcome of one --race contestant
+type raceResult struct {
+	index  int
+	answer string
+	err    error
+}
+
+// raceConfig returns the config for a --race entry of the form
+// provider/model, or just model for the configured provider
+func raceConfig(config *Config, spec, envFile string) (*Config, error) {
+	c := *config
+	c.Silent = true
+	c.usage = &streamStats{} // The contestants run at once, so each counts its own tokens
+	name, model, found := strings.Cut(spec, "/")
+	if !found {
+		name, model = config.Provider, spec
+	}
+	if model == "" {
+		return nil, fmt.Errorf("--race %q 缺少模型名", spec)
+	}
+	c.Model = model
+	if err := switchProvider(&c, name, envFile); err != nil {
+		return nil, fmt.Errorf("--race %q: %w", spec, err)
+	}
+	return &c, nil
+}
+
+// raceAI sends the question to all contestants at once and returns the first
+// answer that arrives. The other requests are cancelled, and waited for, so
+// none of them outlives the race. If every contestant fails, the first
+// error is returned.
+func raceAI(question string, contestants []*Config, specs []string) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	results := make(chan raceResult, len(contestants))
+	for i, c := range contestants {
+		c.Context = ctx
+		go func(i int, c *Config) {
+			answer, err := askAI(question, c)
+			results <- raceResult{i, answer, err}
+		}(i, c)
+	}
+
+	var firstErr error
+	for received := 1; received <= len(contestants); received++ {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", specs[r.index], r.err)
+			}
+			continue
+		}
+		cancel()
+		fmt.Fprintln(os.Stderr, styleText(dimStyle, fmt.Sprintf("race: %s 最先回答 (%.2f 秒)", specs[r.index], time.Since(start).Seconds())))
+		// The cancelled requests return promptly; wait so nothing is left running
+		for ; received < len(contestants); received++ {
+			<-results
+		}
+		return r.answer, nil
+	}
+	return "", firstErr
+}
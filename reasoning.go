@@ -0,0 +1,216 @@
+package main
+
+import (
+	"strings"
+)
+
+// Reasoning models return their chain of thought next to the answer, either
+// in a separate field (reasoning_content, Anthropic thinking blocks) or inline
+// in <think>...</think> tags. The reasoning is never part of the returned
+// answer; it is only displayed, dimmed, when show_reasoning=true.
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+	dimStyle      = "\033[2m"
+	resetStyle    = "\033[0m"
+)
+
+// Default decoration around displayed reasoning
+const (
+	defaultReasoningLabel     = "思考："
+	defaultReasoningSeparator = "\n\n"
+)
+
+// wrapReasoning puts reasoning from a separate response field into <think>
+// tags in front of the answer, so all sources share one rendering path
+func wrapReasoning(reasoning, answer string) string {
+	if reasoning == "" {
+		return answer
+	}
+	return thinkOpenTag + reasoning + thinkCloseTag + answer
+}
+
+// splitReasoning separates a leading <think>...</think> block from the answer
+func splitReasoning(text string) (reasoning, answer string) {
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	if !strings.HasPrefix(trimmed, thinkOpenTag) {
+		return "", text
+	}
+	rest := trimmed[len(thinkOpenTag):]
+	end := strings.Index(rest, thinkCloseTag)
+	if end < 0 {
+		// Unterminated: everything is still reasoning
+		return rest, ""
+	}
+	return rest[:end], strings.TrimLeft(rest[end+len(thinkCloseTag):], "\r\n")
+}
+
+// renderReasoning builds the text displayed for a complete answer
+func renderReasoning(reasoning, answer string, config *Config) string {
+	if !config.ShowReasoning || strings.TrimSpace(reasoning) == "" {
+		return answer
+	}
+	return dimStyle + config.ReasoningLabel + strings.TrimSpace(reasoning) + resetStyle + config.ReasoningSeparator + answer
+}
+
+// reasoningStream renders reasoning and answer deltas of a stream. Content
+// deltas are checked for a leading <think> block, which is routed to the
+// reasoning display instead of the answer.
+type reasoningStream struct {
+	config    *Config
+	pending   string // content held back while a tag may still be incomplete
+	checked   bool   // whether the start of the content has been inspected
+	inThink   bool   // inside an inline <think> block
+	shown     bool   // reasoning display has been started
+	separated bool   // separator after the reasoning has been printed
+	filters   []streamFilter
+	line      string // answer line held back so --highlight sees it whole
+	tag       string // start of a color tag held back until it is complete
+}
+
+func newReasoningStream(config *Config) *reasoningStream {
+	config.styles = &styleStack{}
+	config.markdown = &markdownRenderer{}
+	return &reasoningStream{
+		config:  config,
+		filters: answerFilters(config),
+	}
+}
+
+// Reasoning handles a delta from a dedicated reasoning field
+func (rs *reasoningStream) Reasoning(text string) {
+	if text == "" || !rs.config.ShowReasoning {
+		return
+	}
+	if !rs.shown {
+		rs.shown = true
+		emitDelta(rs.config, dimStyle+rs.config.ReasoningLabel)
+	}
+	emitDelta(rs.config, text)
+}
+
+// Content handles an answer delta and returns the part that belongs to the
+// answer, which has also been displayed
+func (rs *reasoningStream) Content(text string) string {
+	if !rs.checked {
+		rs.pending += text
+		start := strings.TrimLeft(rs.pending, " \t\r\n")
+		if len(start) < len(thinkOpenTag) && strings.HasPrefix(thinkOpenTag, start) {
+			return "" // Could still become <think>
+		}
+		rs.checked = true
+		text, rs.pending = rs.pending, ""
+		if strings.HasPrefix(start, thinkOpenTag) {
+			rs.inThink = true
+			text = start[len(thinkOpenTag):]
+		}
+	}
+
+	if rs.inThink {
+		rs.pending += text
+		if end := strings.Index(rs.pending, thinkCloseTag); end >= 0 {
+			rs.Reasoning(rs.pending[:end])
+			text = strings.TrimLeft(rs.pending[end+len(thinkCloseTag):], "\r\n")
+			rs.pending = ""
+			rs.inThink = false
+		} else {
+			// Hold back enough to recognize a close tag split across deltas
+			keep := len(thinkCloseTag) - 1
+			if len(rs.pending) > keep {
+				rs.Reasoning(rs.pending[:len(rs.pending)-keep])
+				rs.pending = rs.pending[len(rs.pending)-keep:]
+			}
+			return ""
+		}
+	}
+
+	return rs.answer(text)
+}
+
+// answer runs answer text through the answer filters and displays it
+func (rs *reasoningStream) answer(text string) string {
+	return rs.show(feedFilters(rs.filters, text))
+}
+
+// show displays filtered answer text, ending the reasoning display first
+func (rs *reasoningStream) show(text string) string {
+	if text == "" {
+		return ""
+	}
+	if rs.shown && !rs.separated {
+		rs.separated = true
+		emitDelta(rs.config, resetStyle+rs.config.ReasoningSeparator)
+	}
+	if (len(highlightPatterns(rs.config)) > 0 || rs.config.RenderMarkdown) && rs.config.OnDelta == nil {
+		// Matches, color tags and Markdown may be split across deltas
+		rs.line += text
+		end := strings.LastIndex(rs.line, "\n")
+		if end < 0 {
+			return text
+		}
+		emitDelta(rs.config, rs.line[:end+1])
+		rs.line = rs.line[end+1:]
+		return text
+	}
+	display := rs.tag + text
+	rs.tag = ""
+	if keep := partialColorTag(display); keep > 0 && rs.config.OnDelta == nil {
+		rs.tag = display[len(display)-keep:]
+		display = display[:len(display)-keep]
+	}
+	if display != "" {
+		emitDelta(rs.config, display)
+	}
+	return text
+}
+
+// partialColorTag returns the length of what may be the start of a color
+// tag, such as "<re", "</gre" or "<fg=#ff", at the end of text
+func partialColorTag(text string) int {
+	i := strings.LastIndex(text, "<")
+	if i < 0 || len(text)-i >= len("<bg=#RRGGBB>") {
+		return 0
+	}
+	for _, r := range strings.TrimPrefix(text[i+1:], "/") {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'F' || r >= '0' && r <= '9' || r == '=' || r == '#') {
+			return 0
+		}
+	}
+	return len(text) - i
+}
+
+// Stopped reports whether the answer was ended early, e.g. by
+// --stop-after-code, so the rest of the stream can be skipped
+func (rs *reasoningStream) Stopped() bool {
+	return filtersStopped(rs.filters)
+}
+
+// Finish flushes held-back text at the end of the stream and returns any
+// final answer text
+func (rs *reasoningStream) Finish() string {
+	pending := rs.pending
+	rs.pending = ""
+
+	var text string
+	if rs.inThink {
+		rs.Reasoning(pending)
+	} else {
+		text = rs.answer(pending)
+	}
+	text += rs.show(flushFilters(rs.filters))
+	if rs.line != "" {
+		emitDelta(rs.config, rs.line)
+		rs.line = ""
+	}
+	if rs.tag != "" {
+		emitDelta(rs.config, rs.tag)
+		rs.tag = ""
+	}
+
+	if rs.shown && !rs.separated {
+		rs.separated = true
+		emitDelta(rs.config, resetStyle)
+	}
+	return text
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// replHelp lists the commands of the interactive mode
+const replHelp = `命令:
+  /set 名称 值     设置变量，之后的问题中的 {{名称}} 会被替换
+  /set             列出所有变量
+  /unset 名称      删除变量
+  /memory 内容     添加一条记忆，每轮都会加入系统提示
+  /memory          列出所有记忆
+  /memory clear    清空记忆
+  /clear           清空对话记录，开始新的对话
+  /save 文件       保存变量、记忆和对话记录
+  /load 文件       读取变量、记忆和对话记录
+  /help            显示帮助
+  /quit            退出 (也可以按 Ctrl-D)`
+
+// replState is what an interactive session keeps between turns. It is saved
+// and loaded as JSON with /save and /load.
+type replState struct {
+	Vars    map[string]string `json:"vars,omitempty"`
+	Memory  []string          `json:"memory,omitempty"`
+	History []Message         `json:"history,omitempty"` // Earlier questions and answers, sent with every turn
+}
+
+// systemPrompt returns the prompt template with the memory notes appended
+func (s *replState) systemPrompt(template string) string {
+	if len(s.Memory) == 0 {
+		return template
+	}
+	return template + "\n\n请记住以下信息:\n- " + strings.Join(s.Memory, "\n- ")
+}
+
+// save writes the state to a JSON file
+func (s *replState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("保存会话失败: %w", err)
+	}
+	return nil
+}
+
+// load replaces the state with the one saved in a JSON file
+func (s *replState) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取会话失败: %w", err)
+	}
+	var loaded replState
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("解析会话失败: %w", err)
+	}
+	if loaded.Vars == nil {
+		loaded.Vars = map[string]string{}
+	}
+	*s = loaded
+	return nil
+}
+
+// runREPL implements "wen -i": it reads questions from stdin line by line
+// until EOF or /quit. Every turn sends the conversation so far, so the model
+// can refer to earlier answers. It returns the process exit code.
+func runREPL(config *Config) int {
+	config.Silent = true
+	state := &replState{Vars: map[string]string{}}
+	interactive := isTerminal(os.Stdin)
+	if interactive {
+		fmt.Fprintln(os.Stderr, "交互模式，输入 /help 查看命令，/quit 退出")
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Print(styleText("\033[1m", "> "))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			if !replCommand(line, state) {
+				return 0
+			}
+			continue
+		}
+
+		// Work on a copy so nothing accumulates in config across turns
+		c := *config
+		c.PromptTemplate = state.systemPrompt(config.PromptTemplate)
+		c.Messages = append(append([]Message{}, config.Messages...), state.History...)
+		question := limitInput(expandVars(line, state.Vars), config)
+
+		var answer string
+		var err error
+		if c.Stream {
+			answer, _, err = askWithFallback(question, &c, streamAI)
+			endStreamedAnswer(answer)
+		} else if answer, _, err = askWithFallback(question, &c, askAI); err == nil {
+			answer = printAnswer(answer, &c)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "请求AI失败: %v\n", err)
+			continue
+		}
+		state.History = append(state.History,
+			Message{Role: "user", Content: question},
+			Message{Role: "assistant", Content: answer})
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "读取输入失败: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// replCommand runs a /command and reports whether the session continues
+func replCommand(line string, state *replState) bool {
+	fields := strings.Fields(line)
+	name, rest := fields[0], strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+
+	switch name {
+	case "/quit", "/exit":
+		return false
+	case "/help":
+		fmt.Println(replHelp)
+	case "/set":
+		if rest == "" {
+			names := make([]string, 0, len(state.Vars))
+			for n := range state.Vars {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Printf("%s = %s\n", n, state.Vars[n])
+			}
+			break
+		}
+		parts := strings.SplitN(rest, " ", 2)
+		if len(parts) < 2 || !templateVar.MatchString("{{"+parts[0]+"}}") {
+			fmt.Fprintln(os.Stderr, "使用方式: /set 名称 值 (名称只能包含字母、数字和下划线)")
+			break
+		}
+		state.Vars[parts[0]] = strings.TrimSpace(parts[1])
+	case "/unset":
+		delete(state.Vars, rest)
+	case "/clear":
+		state.History = nil
+	case "/memory":
+		switch rest {
+		case "":
+			for i, note := range state.Memory {
+				fmt.Printf("%d. %s\n", i+1, note)
+			}
+		case "clear":
+			state.Memory = nil
+		default:
+			state.Memory = append(state.Memory, rest)
+		}
+	case "/save", "/load":
+		if rest == "" {
+			fmt.Fprintf(os.Stderr, "使用方式: %s 文件\n", name)
+			break
+		}
+		var err error
+		if name == "/save" {
+			err = state.save(rest)
+		} else {
+			err = state.load(rest)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "未知命令 %s，输入 /help 查看命令\n", name)
+	}
+	return true
+}
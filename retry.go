@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultMaxRetries is how often a transient failure is retried unless
+// max_retries is configured
+const defaultMaxRetries = 2
+
+// retryBaseDelay is the wait before the first retry; it doubles with every
+// further one
+const retryBaseDelay = time.Second
+
+// maxRetryAfter is the longest Retry-After wait honoured. A server asking
+// for more, as with a daily quota, gets its error returned instead.
+const maxRetryAfter = time.Minute
+
+// retryable reports whether a response with this status is worth retrying:
+// rate limiting and the server errors that usually pass
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the wait a Retry-After header asks for, given in seconds
+// or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// timeoutBody closes the request's timeout together with the response body,
+// and restarts the stream's idle timer whenever data is read
+type timeoutBody struct {
+	io.ReadCloser
+	timeout *requestTimeout
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timeout.received()
+	}
+	if err != nil && err != io.EOF {
+		err = b.timeout.check(err)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.timeout.release()
+	return err
+}
+
+// doRequest sends an API request, retrying 429 and 5xx gateway errors up to
+// max_retries times with exponential backoff, or after the wait a
+// Retry-After header asks for, up to maxRetryAfter. Every attempt has its
+// own timeout; for a stream it ends once the response has started. Other
+// responses, including errors, are returned to the caller as they are.
+func doRequest(req *http.Request, config *Config, stream bool) (*http.Response, error) {
+	client := httpClient(config)
+	delay := retryBaseDelay
+	for retry := 0; ; retry++ {
+		attempt := req
+		if retry > 0 {
+			attempt = req.Clone(req.Context())
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("发送请求失败: %w", err)
+			}
+			attempt.Body = body
+		}
+		attempt, timeout := withTimeout(attempt, config)
+
+		resp, err := client.Do(attempt)
+		if err != nil {
+			timeout.release()
+			config.attempts.count(0)
+			return nil, networkFailure(timeout.check(fmt.Errorf("发送请求失败: %w", err)))
+		}
+		config.attempts.count(resp.StatusCode)
+		if stream {
+			timeout.started()
+		}
+		wait, ok := retryAfter(resp)
+		if !ok {
+			wait = delay
+		}
+		if !retryable(resp.StatusCode) || retry >= config.MaxRetries || ok && wait > maxRetryAfter {
+			resp.Body = &timeoutBody{ReadCloser: resp.Body, timeout: timeout}
+			return resp, nil
+		}
+		delay *= 2
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		timeout.release()
+
+		notice("API返回 %d，%g 秒后重试 (%d/%d)\n", resp.StatusCode, math.Round(wait.Seconds()*10)/10, retry+1, config.MaxRetries)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, networkFailure(fmt.Errorf("发送请求失败: %w", req.Context().Err()))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDoRequestLongRetryAfter checks that a Retry-After longer than
+// maxRetryAfter is not waited for but returned as the response
+func TestDoRequestLongRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "86400")
+		http.Error(w, `{"error":{"message":"daily quota exceeded"}}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	config := newConfig()
+	config.MaxRetries = 2
+	req, err := http.NewRequest("POST", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := doRequest(req, config, false)
+	if err != nil {
+		t.Fatalf("doRequest: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("%d requests, want 1", got)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %v, want no wait", elapsed)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// frontMatterDelim opens and closes the front-matter of a .wen file
+const frontMatterDelim = "---"
+
+// promptFile is a parsed .wen file: settings from the front-matter and the
+// prompt body
+type promptFile struct {
+	Model       string
+	Provider    string
+	Temperature *float64
+	System      *string // Nil keeps the configured prompt template
+	Body        string
+}
+
+// parsePromptFile splits a .wen file into its front-matter and body. The
+// front-matter is a small subset of YAML: "key: value" lines, optionally
+// quoted, and "key: |" followed by an indented block. Files without
+// front-matter are all body.
+func parsePromptFile(data string) (*promptFile, error) {
+	data = strings.TrimPrefix(data, "\ufeff")
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	pf := &promptFile{}
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterDelim {
+		pf.Body = strings.TrimSpace(data)
+		return pf, nil
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == frontMatterDelim {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("front-matter 缺少结束的 %s", frontMatterDelim)
+	}
+	pf.Body = strings.TrimSpace(strings.Join(lines[end+1:], "\n"))
+
+	header := lines[1:end]
+	for i := 0; i < len(header); i++ {
+		line := header[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("front-matter 第 %d 行格式错误: %q", i+2, line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if value == "|" || value == ">" {
+			// Block scalar: the following indented lines
+			var block []string
+			for i+1 < len(header) && (strings.TrimSpace(header[i+1]) == "" || strings.HasPrefix(header[i+1], " ") || strings.HasPrefix(header[i+1], "\t")) {
+				i++
+				block = append(block, header[i])
+			}
+			sep := "\n"
+			if value == ">" {
+				sep = " "
+			}
+			value = strings.Join(dedent(block), sep)
+		} else {
+			value = unquoteYAML(value)
+		}
+
+		switch key {
+		case "model":
+			pf.Model = value
+		case "provider":
+			pf.Provider = value
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("front-matter 中的 temperature 无效: %q", value)
+			}
+			pf.Temperature = &t
+		case "system":
+			pf.System = &value
+		default:
+			return nil, fmt.Errorf("front-matter 中有未知的设置 %q", key)
+		}
+	}
+	return pf, nil
+}
+
+// dedent removes the indentation of the first non-empty line from all lines
+// of a block and drops trailing empty lines
+func dedent(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := ""
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			indent = l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			break
+		}
+	}
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = strings.TrimPrefix(l, indent)
+	}
+	return out
+}
+
+// unquoteYAML strips matching single or double quotes from a scalar; a
+// trailing " #" comment is dropped from unquoted values
+func unquoteYAML(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		if value[0] == '"' {
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// runVars turns the arguments after the file into template variables:
+// name=value pairs, and the others by position as {{1}}, {{2}}, ...
+func runVars(args []string) map[string]string {
+	vars := map[string]string{}
+	n := 0
+	for _, arg := range args {
+		if name, value, found := strings.Cut(arg, "="); found && templateVar.MatchString("{{"+name+"}}") {
+			vars[name] = value
+			continue
+		}
+		n++
+		vars[strconv.Itoa(n)] = arg
+	}
+	return vars
+}
+
+// runPromptFile implements "wen run <file.wen> [name=value...]": it asks the
+// prompt in the file's body with the settings of its front-matter, after
+// filling in {{name}} variables from the remaining arguments. It returns the
+// process exit code.
+func runPromptFile(args []string) int {
+	opts, words, err := parseArgs(args, nil)
+	if err != nil || len(words) < 1 {
+		fmt.Fprintln(os.Stderr, "使用方式: ./wen run [选项] <文件.wen> [名称=值...]")
+		return 1
+	}
+	if err := startProfiling(opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer stopProfiling()
+	defer flushTelemetry()
+
+	data, err := os.ReadFile(words[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法读取文件: %v\n", err)
+		return 1
+	}
+	pf, err := parsePromptFile(string(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", words[0], err)
+		return 1
+	}
+	vars := runVars(words[1:])
+	question := expandVars(pf.Body, vars)
+	if question == "" {
+		fmt.Fprintf(os.Stderr, "%s 中没有提示内容\n", words[0])
+		return 1
+	}
+
+	config, err := loadDefaultConfig(opts.ConfigFile, opts.Profile, opts.EnvFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法加载配置文件: %v\n", err)
+		return 1
+	}
+	// The file overrides the config; the command line overrides both
+	if pf.Provider != "" {
+		if err := switchProvider(config, pf.Provider, opts.EnvFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", words[0], err)
+			return 1
+		}
+	}
+	if pf.Model != "" {
+		config.Model = pf.Model
+	}
+	if pf.Temperature != nil {
+		config.Temperature = pf.Temperature
+	}
+	if pf.System != nil {
+		config.PromptTemplate = expandVars(*pf.System, vars)
+	}
+	if err := applyOptions(config, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config.Silent = true
+	question = limitInput(question, config)
+
+	ask := askAI
+	if config.Stream {
+		ask = streamAI
+	}
+	answer, attempts, err := askWithFallback(question, config, ask)
+	if err != nil {
+		reportAttempts(config, attempts)
+		fmt.Fprintf(os.Stderr, "请求AI失败: %v\n", err)
+		return exitCode(err)
+	}
+	if config.Stream {
+		endStreamedAnswer(answer)
+	} else {
+		printAnswer(answer, config)
+	}
+	reportAttempts(config, attempts)
+	return 0
+}
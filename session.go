@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultSessionMaxMessages is how many messages a session keeps unless
+// session_max_messages is configured
+const defaultSessionMaxMessages = 40
+
+// sessionPath returns the file of a --session, in the sessions directory
+// under configDir
+func sessionPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("会话名 %q 无效", name)
+	}
+	dir := configDir()
+	if dir == "" {
+		return "", fmt.Errorf("找不到主目录，无法保存会话")
+	}
+	return filepath.Join(dir, "sessions", name+".json"), nil
+}
+
+// lastExchangePath returns the file holding the last question and answer,
+// which --continue follows up on. There is no shared fallback such as the
+// temporary directory, where other users could read or replace it.
+func lastExchangePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("找不到缓存目录，无法保存上一次的问答: %w", err)
+	}
+	return filepath.Join(dir, "wen", "last.json"), nil
+}
+
+// loadSession reads the messages of a session; a session that was never
+// saved is empty
+func loadSession(config *Config, path string) ([]Message, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	var messages []Message
+	err := withLock(config, sessionLockFile(path), false, func() error {
+		var err error
+		messages, err = loadMessages(path, config.Provider)
+		return err
+	})
+	return messages, err
+}
+
+// saveSession appends a question and its answer to the session and writes
+// it back, keeping only the last max messages. Whole turns are dropped so
+// the history still starts with a question. The session is read and
+// replaced under one lock, so turns saved by wen processes running at the
+// same time are all kept and the file is never left half written.
+func saveSession(config *Config, path string, question, answer string, max int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("保存会话失败: %w", err)
+	}
+	err := withLock(config, sessionLockFile(path), true, func() error {
+		var history []Message
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			var err error
+			if history, err = loadMessages(path, config.Provider); err != nil {
+				return err
+			}
+		}
+		history = append(history,
+			Message{Role: "user", Content: question},
+			Message{Role: "assistant", Content: answer})
+		if max > 0 && len(history) > max {
+			history = history[len(history)-max:]
+			for len(history) > 0 && history[0].Role != "user" {
+				history = history[1:]
+			}
+		}
+		data, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		// Readers without a lock still see either the old or the new file
+		tmp, err := os.CreateTemp(dir, "tmp-*")
+		if err != nil {
+			return err
+		}
+		_, werr := tmp.Write(data)
+		cerr := tmp.Close()
+		if werr != nil || cerr != nil {
+			os.Remove(tmp.Name())
+			return errors.Join(werr, cerr)
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("保存会话失败: %w", err)
+	}
+	return nil
+}
+
+// sessionLockFile is the lock guarding a session file
+func sessionLockFile(path string) string {
+	return path + ".lock"
+}
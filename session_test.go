@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestSaveSessionConcurrent saves turns of one session from several
+// goroutines at once and checks that none of them is lost
+func TestSaveSessionConcurrent(t *testing.T) {
+	config := newConfig()
+	config.FileLocking = true
+	path := filepath.Join(t.TempDir(), "sessions", "test.json")
+
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := saveSession(config, path, fmt.Sprintf("question %d", i), fmt.Sprintf("answer %d", i), 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	history, err := loadSession(config, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2*writers {
+		t.Fatalf("%d messages, want %d", len(history), 2*writers)
+	}
+	for i := 0; i < len(history); i += 2 {
+		if history[i].Role != "user" || history[i+1].Role != "assistant" {
+			t.Errorf("turn %d has roles %s, %s; want user, assistant", i/2, history[i].Role, history[i+1].Role)
+		}
+	}
+
+	// Only the last turns are kept
+	if err := saveSession(config, path, "last question", "last answer", 4); err != nil {
+		t.Fatal(err)
+	}
+	history, err = loadSession(config, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 || history[2].Content != "last question" {
+		t.Errorf("history = %v, want the last two turns", history)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// defaultLogPrompt is the question used with --since-file when none is given
+const defaultLogPrompt = "以下是日志文件自上次检查以来新增的内容。请总结发生了什么，并指出需要关注的错误、警告或异常情况。"
+
+// stateDir returns the directory for wen's persistent state files
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "wen")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "wen")
+	}
+	return filepath.Join(home, ".local", "state", "wen")
+}
+
+// offsetsFile holds the byte offsets already read from each log file
+func offsetsFile() string {
+	return filepath.Join(stateDir(), "offsets.json")
+}
+
+// offsetsLockFile guards reading and updating offsetsFile
+func offsetsLockFile() string {
+	return offsetsFile() + ".lock"
+}
+
+// loadOffsets reads the saved offsets; a missing or corrupt file means none
+func loadOffsets() map[string]int64 {
+	offsets := map[string]int64{}
+	data, err := os.ReadFile(offsetsFile())
+	if err == nil {
+		json.Unmarshal(data, &offsets)
+	}
+	return offsets
+}
+
+// saveOffsets writes the offsets back to the state file
+func saveOffsets(offsets map[string]int64) error {
+	if err := os.MkdirAll(stateDir(), 0o700); err != nil {
+		return fmt.Errorf("保存日志读取位置失败: %w", err)
+	}
+	data, err := json.MarshalIndent(offsets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(offsetsFile(), data, 0o600); err != nil {
+		return fmt.Errorf("保存日志读取位置失败: %w", err)
+	}
+	return nil
+}
+
+// readSinceLast returns what was appended to the log since the previous run
+// and a function that records the new offset once the content was handled.
+// If the file is now smaller than the saved offset it was rotated or
+// truncated, and reading starts over from the beginning.
+func readSinceLast(path string, config *Config) (string, func() error, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("无法解析日志路径: %w", err)
+	}
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return "", nil, fmt.Errorf("打开日志文件失败: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, fmt.Errorf("读取日志文件失败: %w", err)
+	}
+
+	var offset int64
+	err = withLock(config, offsetsLockFile(), false, func() error {
+		offset = loadOffsets()[abs]
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if offset > info.Size() {
+		offset = 0
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("读取日志文件失败: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("读取日志文件失败: %w", err)
+	}
+
+	end := offset + int64(len(data))
+	commit := func() error {
+		// Reload under the lock so concurrent runs on other files are not
+		// overwritten
+		return withLock(config, offsetsLockFile(), true, func() error {
+			offsets := loadOffsets()
+			offsets[abs] = end
+			return saveOffsets(offsets)
+		})
+	}
+	return string(data), commit, nil
+}
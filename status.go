@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusInterval is how often the live status line is redrawn
+const statusInterval = 100 * time.Millisecond
+
+// liveStatus is the status line of the stream in progress; nil when none is
+// shown. All statusLine methods are safe to call on nil.
+var liveStatus *statusLine
+
+// statusLine shows elapsed time and an estimated token count on stderr while
+// an answer streams in. It is drawn just after the cursor, with the cursor
+// position saved and restored around it, and erased before each write to
+// stdout, so the answer text is never mixed up with it.
+type statusLine struct {
+	mu      sync.Mutex
+	start   time.Time
+	text    strings.Builder // Streamed text so far, for the token estimate
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// startStatusLine starts the status line if it is enabled and both stdout
+// and stderr are terminals, and returns it; otherwise it returns nil
+func startStatusLine(config *Config) *statusLine {
+	if !config.StatusLine || config.rawStream() || config.OnDelta != nil || !isTerminal(os.Stdout) || !isTerminal(os.Stderr) {
+		return nil
+	}
+
+	s := &statusLine{start: time.Now(), stop: make(chan struct{})}
+	s.stopped.Add(1)
+	go func() {
+		defer s.stopped.Done()
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.draw()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// draw shows the current status after the cursor
+func (s *statusLine) draw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := fmt.Sprintf(" ▌ %.1fs · ~%d tok", time.Since(s.start).Seconds(), estimateTokens(s.text.String()))
+	fmt.Fprint(os.Stderr, "\0337"+styleText(dimStyle, status)+"\0338")
+}
+
+// Write erases the status and prints text to stdout; it is redrawn on the
+// next tick
+func (s *statusLine) Write(text, formatted string) {
+	if s == nil {
+		fmt.Print(formatted)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.text.WriteString(text)
+	fmt.Fprint(os.Stderr, "\033[K")
+	fmt.Print(formatted)
+}
+
+// Stop ends the status line and erases it
+func (s *statusLine) Stop() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+		s.mu.Unlock()
+		return // Already stopped
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	s.stopped.Wait()
+	fmt.Fprint(os.Stderr, "\033[K")
+}
+
+// spinnerFrames are drawn in turn while waiting for a non-streamed answer
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// liveSpinner is the spinner shown while a non-streamed answer is awaited;
+// nil when none is shown
+var liveSpinner *spinner
+
+// spinner is the animated wait indicator drawn by startSpinner
+type spinner struct {
+	mu    sync.Mutex
+	start time.Time
+	frame int
+}
+
+// startSpinner shows an animated spinner with the elapsed time on stderr
+// while a non-streamed answer is awaited, and returns the function that
+// stops and erases it. Nothing is shown when stderr is not a terminal, in
+// quiet or silent (e.g. --json) mode, or when debug or verbose output could
+// be written to stderr in the meantime. Other messages written meanwhile
+// go through notice, so the spinner does not draw over them.
+func startSpinner(config *Config) func() {
+	if config.Quiet || config.Silent || config.Verbose || config.debugEnabled() || !isTerminal(os.Stderr) {
+		return func() {}
+	}
+
+	s := &spinner{start: time.Now()}
+	liveSpinner = s
+	stop := make(chan struct{})
+	var stopped sync.WaitGroup
+	stopped.Add(1)
+	go func() {
+		defer stopped.Done()
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.draw()
+			case <-stop:
+				s.mu.Lock()
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				s.mu.Unlock()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			stopped.Wait()
+			liveSpinner = nil
+		})
+	}
+}
+
+// draw shows the next frame of the spinner
+func (s *spinner) draw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame := spinnerFrames[s.frame%len(spinnerFrames)]
+	s.frame++
+	fmt.Fprint(os.Stderr, "\r"+styleText(dimStyle, fmt.Sprintf("%s 等待回答 %.1fs", frame, time.Since(s.start).Seconds()))+"\033[K")
+}
+
+// notice prints a message on stderr while an answer is awaited. A spinner
+// is erased first and redrawn below the message on its next tick.
+func notice(format string, args ...interface{}) {
+	if s := liveSpinner; s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+}
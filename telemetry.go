@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// telemetryTimeout bounds a single export to the collector
+	telemetryTimeout = 5 * time.Second
+	// telemetryFlushTimeout is how long wen waits for pending exports before
+	// exiting; anything slower is dropped
+	telemetryFlushTimeout = 2 * time.Second
+)
+
+// pendingExports tracks spans still being sent to the collector
+var pendingExports sync.WaitGroup
+
+// requestSpan measures one API request for OTLP export. All methods are
+// safe to call on nil, which is what startSpan returns when no otlp_endpoint
+// is configured.
+type requestSpan struct {
+	config *Config
+	stream bool
+	start  time.Time
+	status int
+	stats  streamStats
+	err    error
+}
+
+// startSpan starts timing a request if telemetry is enabled
+func startSpan(config *Config, stream bool) *requestSpan {
+	if config.OTLPEndpoint == "" {
+		return nil
+	}
+	return &requestSpan{config: config, stream: stream, start: time.Now()}
+}
+
+// fail records the error the request ended with and returns it
+func (s *requestSpan) fail(err error) error {
+	if s != nil {
+		s.err = err
+	}
+	return err
+}
+
+// setStatus records the HTTP status code of the response
+func (s *requestSpan) setStatus(code int) {
+	if s != nil {
+		s.status = code
+	}
+}
+
+// setStats records the token usage of the response
+func (s *requestSpan) setStats(stats streamStats) {
+	if s != nil {
+		s.stats = stats
+	}
+}
+
+// End finishes the span and exports it in the background. Export failures
+// are ignored; telemetry must never affect the answer.
+func (s *requestSpan) End() {
+	if s == nil {
+		return
+	}
+	body, err := json.Marshal(s.otlpTrace(time.Now()))
+	if err != nil {
+		return
+	}
+
+	url := strings.TrimSuffix(s.config.OTLPEndpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	pendingExports.Add(1)
+	go func() {
+		defer pendingExports.Done()
+		// The API's client, so that the export goes through the proxy too
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := httpClient(s.config).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
+
+// flushTelemetry waits a short while for spans that are still being sent
+func flushTelemetry() {
+	done := make(chan struct{})
+	go func() {
+		pendingExports.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(telemetryFlushTimeout):
+	}
+}
+
+// otlpAttribute is a key/value pair in OTLP/JSON form
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, map[string]interface{}{"stringValue": value}}
+}
+
+// intAttribute encodes an integer; OTLP/JSON sends 64-bit integers as strings
+func intAttribute(key string, value int64) otlpAttribute {
+	return otlpAttribute{key, map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func boolAttribute(key string, value bool) otlpAttribute {
+	return otlpAttribute{key, map[string]interface{}{"boolValue": value}}
+}
+
+// otlpTrace builds the OTLP/JSON export request holding this span
+func (s *requestSpan) otlpTrace(end time.Time) map[string]interface{} {
+	attributes := []otlpAttribute{
+		stringAttribute("gen_ai.system", s.config.Provider),
+		stringAttribute("gen_ai.request.model", s.config.Model),
+		boolAttribute("wen.stream", s.stream),
+		intAttribute("wen.latency_ms", end.Sub(s.start).Milliseconds()),
+	}
+	if s.status != 0 {
+		attributes = append(attributes, intAttribute("http.response.status_code", int64(s.status)))
+	}
+	if s.stats.InputTokens > 0 || s.stats.OutputTokens > 0 {
+		attributes = append(attributes,
+			intAttribute("gen_ai.usage.input_tokens", int64(s.stats.InputTokens)),
+			intAttribute("gen_ai.usage.output_tokens", int64(s.stats.OutputTokens)))
+	}
+	if s.stats.StopReason != "" {
+		attributes = append(attributes, stringAttribute("gen_ai.response.finish_reasons", s.stats.StopReason))
+	}
+
+	status := map[string]interface{}{"code": 1} // Ok
+	if s.err != nil {
+		status = map[string]interface{}{"code": 2, "message": s.err.Error()} // Error
+	}
+
+	span := map[string]interface{}{
+		"traceId":           randomHex(16),
+		"spanId":            randomHex(8),
+		"name":              "wen.request",
+		"kind":              3, // Client
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+		"attributes":        attributes,
+		"status":            status,
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{stringAttribute("service.name", "wen")},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "wen"},
+				"spans": []interface{}{span},
+			}},
+		}},
+	}
+}
+
+// randomHex returns n random bytes as hex, for trace and span IDs
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// localeFormat holds the time layouts used for one locale
+type localeFormat struct {
+	Date string
+	Time string
+}
+
+// isoFormat is used when no locale is configured or the locale is unknown
+var isoFormat = localeFormat{Date: "2006-01-02", Time: "15:04"}
+
+// localeFormats maps a language (optionally with region) to its layouts.
+// Lookups try the full tag first and then the bare language.
+var localeFormats = map[string]localeFormat{
+	"zh":    {Date: "2006年1月2日", Time: "15:04"},
+	"zh-tw": {Date: "2006年1月2日", Time: "15:04"},
+	"ja":    {Date: "2006年1月2日", Time: "15:04"},
+	"ko":    {Date: "2006년 1월 2일", Time: "15:04"},
+	"en":    {Date: "January 2, 2006", Time: "3:04 PM"},
+	"en-gb": {Date: "2 January 2006", Time: "15:04"},
+	"de":    {Date: "02.01.2006", Time: "15:04"},
+	"fr":    {Date: "02/01/2006", Time: "15:04"},
+	"es":    {Date: "02/01/2006", Time: "15:04"},
+	"ru":    {Date: "02.01.2006", Time: "15:04"},
+}
+
+// lookupLocale finds the layouts for a locale such as "zh_CN.UTF-8" or "en-GB"
+func lookupLocale(locale string) localeFormat {
+	tag := strings.ToLower(locale)
+	if i := strings.IndexAny(tag, ".@"); i >= 0 {
+		tag = tag[:i]
+	}
+	tag = strings.ReplaceAll(tag, "_", "-")
+
+	if f, ok := localeFormats[tag]; ok {
+		return f
+	}
+	if i := strings.Index(tag, "-"); i >= 0 {
+		if f, ok := localeFormats[tag[:i]]; ok {
+			return f
+		}
+	}
+	return isoFormat
+}
+
+// renderPromptTemplate expands the {{date}} and {{time}} variables of a
+// prompt template using the configured locale
+func renderPromptTemplate(template string, config *Config) string {
+	if !strings.Contains(template, "{{") {
+		return template
+	}
+
+	now := time.Now()
+	f := lookupLocale(config.Locale)
+	return strings.NewReplacer(
+		"{{date}}", now.Format(f.Date),
+		"{{time}}", now.Format(f.Time),
+	).Replace(template)
+}
+
+// templateVar matches a {{name}} placeholder
+var templateVar = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// expandVars replaces {{name}} placeholders with the given variables. Unknown
+// names are left as they are, so {{date}} and {{time}} still work later.
+func expandVars(text string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(text, "{{") {
+		return text
+	}
+	return templateVar.ReplaceAllStringFunc(text, func(match string) string {
+		name := templateVar.FindStringSubmatch(match)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return match
+	})
+}
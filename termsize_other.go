@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// ttyWidth returns 0: the terminal size is not queried on this platform, so
+// $COLUMNS or the default is used
+func ttyWidth(f *os.File) int {
+	return 0
+}
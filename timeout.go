@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTimeout is how many seconds a request may take unless timeout is
+// configured
+const defaultTimeout = 60
+
+// requestTimeout cancels a request that is not answered within the
+// configured timeout. A complete response has to arrive in time; a stream
+// only has to start, since a long answer may take minutes to generate.
+// Once a stream has started, stream_idle_timeout limits the wait for the
+// next data instead.
+type requestTimeout struct {
+	timer     *time.Timer // Nil while nothing is timed
+	cancel    context.CancelFunc
+	seconds   int
+	idle      int  // Seconds a started stream may go without data
+	streaming bool // The stream has started, so the timer is the idle one
+	fired     atomic.Bool
+}
+
+// withTimeout returns req bound to a new timeout, or req unchanged and a nil
+// timeout when timeout=0 and stream_idle_timeout=0 disable both. The methods
+// accept a nil timeout.
+func withTimeout(req *http.Request, config *Config) (*http.Request, *requestTimeout) {
+	if config.Timeout <= 0 && config.StreamIdleTimeout <= 0 {
+		return req, nil
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	t := &requestTimeout{cancel: cancel, seconds: config.Timeout, idle: config.StreamIdleTimeout}
+	if config.Timeout > 0 {
+		t.timer = time.AfterFunc(time.Duration(config.Timeout)*time.Second, t.fire)
+	}
+	return req.WithContext(ctx), t
+}
+
+// fire cancels the request when the timer runs out
+func (t *requestTimeout) fire() {
+	t.fired.Store(true)
+	t.cancel()
+}
+
+// started stops the timer once a stream has begun, and starts the idle
+// timer if stream_idle_timeout is set
+func (t *requestTimeout) started() {
+	if t == nil {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if t.idle > 0 {
+		t.streaming = true
+		t.timer = time.AfterFunc(time.Duration(t.idle)*time.Second, t.fire)
+	}
+}
+
+// received restarts the idle timer when stream data arrives
+func (t *requestTimeout) received() {
+	if t != nil && t.streaming {
+		t.timer.Reset(time.Duration(t.idle) * time.Second)
+	}
+}
+
+// release frees the timeout when the request is done with
+func (t *requestTimeout) release() {
+	if t != nil {
+		if t.timer != nil {
+			t.timer.Stop()
+		}
+		t.cancel()
+	}
+}
+
+// check replaces the error of a request cancelled by the timeout with one
+// saying so
+func (t *requestTimeout) check(err error) error {
+	if t == nil || !t.fired.Load() {
+		return err
+	}
+	if t.streaming {
+		return fmt.Errorf("%d 秒内没有收到新的数据 (可用 stream_idle_timeout 配置调整)", t.idle)
+	}
+	return fmt.Errorf("请求超时: %d 秒内没有响应 (可用 timeout 配置调整)", t.seconds)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// toolCall is a single function call requested by the model
+type toolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// loadTools reads the OpenAI "tools" array from the given file
+func loadTools(path string) ([]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取工具定义失败: %w", err)
+	}
+
+	var tools []interface{}
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return nil, fmt.Errorf("解析工具定义失败: %w", err)
+	}
+
+	return tools, nil
+}
+
+// addOpenAITools adds tools, tool_choice and parallel_tool_calls to an OpenAI
+// request body. Nothing is added when no tools are configured, because the
+// API rejects tool_choice and parallel_tool_calls without tools.
+func addOpenAITools(requestBody map[string]interface{}, config *Config) error {
+	if config.ToolsFile == "" {
+		return nil
+	}
+
+	tools, err := loadTools(config.ToolsFile)
+	if err != nil {
+		return err
+	}
+	if len(tools) == 0 {
+		return nil
+	}
+	requestBody["tools"] = tools
+
+	switch config.ToolChoice {
+	case "":
+	case "auto", "none", "required":
+		requestBody["tool_choice"] = config.ToolChoice
+	default:
+		// Any other value names the function the model must call
+		requestBody["tool_choice"] = map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": config.ToolChoice},
+		}
+	}
+
+	if config.ParallelToolCalls != nil {
+		requestBody["parallel_tool_calls"] = *config.ParallelToolCalls
+	}
+
+	return nil
+}
+
+// maxToolCalls bounds the tool call index of a stream, so a malformed index
+// cannot make wen allocate an enormous list
+const maxToolCalls = 128
+
+// mergeToolCallDeltas folds streamed tool call fragments into calls. The
+// stream sends the name once and the arguments in pieces, keyed by index.
+// Deltas with an index out of range are malformed and skipped.
+func mergeToolCallDeltas(calls []toolCall, deltas []toolCall) []toolCall {
+	for _, d := range deltas {
+		if d.Index < 0 || d.Index >= maxToolCalls {
+			continue
+		}
+		for len(calls) <= d.Index {
+			calls = append(calls, toolCall{Index: len(calls)})
+		}
+		c := &calls[d.Index]
+		if d.ID != "" {
+			c.ID = d.ID
+		}
+		if d.Function.Name != "" {
+			c.Function.Name = d.Function.Name
+		}
+		c.Function.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+// formatToolCalls renders the requested tool calls as tagged text so they pass
+// through processTerminalFormatting like a normal answer
+func formatToolCalls(calls []toolCall) string {
+	var b strings.Builder
+	for _, c := range calls {
+		fmt.Fprintf(&b, "<bold>调用工具:</bold> <green>%s</green>(%s)\n", c.Function.Name, c.Function.Arguments)
+	}
+	return b.String()
+}
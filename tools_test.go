@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestMergeToolCallDeltas(t *testing.T) {
+	delta := func(index int, name, args string) toolCall {
+		var c toolCall
+		c.Index = index
+		c.Function.Name = name
+		c.Function.Arguments = args
+		return c
+	}
+
+	var calls []toolCall
+	calls = mergeToolCallDeltas(calls, []toolCall{delta(0, "get_weather", `{"city":`)})
+	calls = mergeToolCallDeltas(calls, []toolCall{delta(-1, "", "bad"), delta(maxToolCalls, "", "bad")})
+	calls = mergeToolCallDeltas(calls, []toolCall{delta(0, "", `"Paris"}`), delta(1, "get_time", "{}")})
+
+	if len(calls) != 2 {
+		t.Fatalf("%d calls, want 2", len(calls))
+	}
+	if c := calls[0]; c.Function.Name != "get_weather" || c.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("call 0 = %s(%s), want get_weather({\"city\":\"Paris\"})", c.Function.Name, c.Function.Arguments)
+	}
+	if c := calls[1]; c.Function.Name != "get_time" || c.Function.Arguments != "{}" {
+		t.Errorf("call 1 = %s(%s), want get_time({})", c.Function.Name, c.Function.Arguments)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// shellCommand builds a command that runs line through the system shell
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
+}
+
+// transformRequest pipes the request body through transform_cmd and returns
+// the body it prints. The original body is kept when the command fails or
+// does not print a JSON object, so a broken hook never blocks a request.
+func transformRequest(requestBody []byte, config *Config) []byte {
+	if config.TransformCmd == "" {
+		return requestBody
+	}
+
+	cmd := shellCommand(config.TransformCmd)
+	cmd.Stdin = bytes.NewReader(requestBody)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "transform_cmd 执行失败，使用原始请求: %v\n", err)
+		return requestBody
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(out, &body); err != nil {
+		fmt.Fprintf(os.Stderr, "transform_cmd 输出的不是有效的 JSON 对象，使用原始请求: %v\n", err)
+		return requestBody
+	}
+
+	return bytes.TrimSpace(out)
+}
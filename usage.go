@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// streamStats is what a stream reports besides the answer text: the final
+// token usage and why generation stopped
+type streamStats struct {
+	InputTokens  int
+	OutputTokens int
+	StopReason   string // As sent by the provider, e.g. "end_turn", "length" or "MAX_TOKENS"
+	StoppedEarly bool   // wen cancelled the stream itself, see --stop-after-code
+
+	// Anthropic prompt caching, see prompt_cache
+	CacheCreationTokens int
+	CacheReadTokens     int
+}
+
+// anthropicUsage is the usage object of Anthropic responses and stream events
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// truncated reports whether generation stopped at the output token limit
+func (s streamStats) truncated() bool {
+	return s.StopReason == "max_tokens" || s.StopReason == "length" || s.StopReason == "MAX_TOKENS"
+}
+
+// addUsage adds the tokens of a response to config.usage, if it is set
+func (config *Config) addUsage(stats streamStats) {
+	if config.usage == nil {
+		return
+	}
+	config.usage.InputTokens += stats.InputTokens
+	config.usage.OutputTokens += stats.OutputTokens
+	config.usage.CacheCreationTokens += stats.CacheCreationTokens
+	config.usage.CacheReadTokens += stats.CacheReadTokens
+}
+
+// formatUsage describes the token usage for the timing line, e.g.
+// "tokens: 125 in / 430 out", or returns "" when the provider reported none
+func formatUsage(stats streamStats) string {
+	if stats.InputTokens == 0 && stats.OutputTokens == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("tokens: %d in / %d out", stats.InputTokens, stats.OutputTokens)
+	if stats.CacheCreationTokens > 0 || stats.CacheReadTokens > 0 {
+		line += fmt.Sprintf(" (缓存写入 %d，缓存读取 %d)", stats.CacheCreationTokens, stats.CacheReadTokens)
+	}
+	return line
+}
+
+// reportStreamStats warns on stderr when the answer was cut off and, with
+// --verbose, prints the token usage unless it goes on the timing line
+func reportStreamStats(config *Config, stats streamStats) {
+	if config.rawStream() {
+		return // The stream itself carries this information
+	}
+	var lines []string
+	if stats.StoppedEarly {
+		lines = append(lines, styleText(dimStyle, "已在第一个代码块结束后停止生成"))
+	}
+	if stats.truncated() {
+		lines = append(lines, styleText("\033[33m", "注意: 回答达到最大 token 数，已被截断"))
+	}
+	if config.Verbose && config.usage == nil && (stats.InputTokens > 0 || stats.OutputTokens > 0) {
+		line := fmt.Sprintf("用量: 输入 %d tokens，输出 %d tokens", stats.InputTokens, stats.OutputTokens)
+		if stats.CacheCreationTokens > 0 || stats.CacheReadTokens > 0 {
+			line += fmt.Sprintf("，缓存写入 %d tokens，缓存读取 %d tokens", stats.CacheCreationTokens, stats.CacheReadTokens)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		// The streamed answer usually ends without a newline
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, strings.Join(lines, "\n"))
+	}
+}
+
+// responseStats reads the token usage and stop reason of a complete
+// (non-streamed) response
+func responseStats(provider string, body []byte) streamStats {
+	var stats streamStats
+	if provider == "anthropic" {
+		var response struct {
+			StopReason string         `json:"stop_reason"`
+			Usage      anthropicUsage `json:"usage"`
+		}
+		if json.Unmarshal(body, &response) == nil {
+			stats.InputTokens = response.Usage.InputTokens
+			stats.OutputTokens = response.Usage.OutputTokens
+			stats.CacheCreationTokens = response.Usage.CacheCreationInputTokens
+			stats.CacheReadTokens = response.Usage.CacheReadInputTokens
+			stats.StopReason = response.StopReason
+		}
+		return stats
+	}
+	if provider == "gemini" {
+		var response geminiResponse
+		if json.Unmarshal(body, &response) == nil {
+			stats = response.stats()
+		}
+		return stats
+	}
+	if provider == "ollama" {
+		var response ollamaResponse
+		if json.Unmarshal(body, &response) == nil {
+			stats = response.stats()
+		}
+		return stats
+	}
+
+	var response struct {
+		Choices []struct {
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(body, &response) == nil {
+		stats.InputTokens = response.Usage.PromptTokens
+		stats.OutputTokens = response.Usage.CompletionTokens
+		if len(response.Choices) > 0 {
+			stats.StopReason = response.Choices[0].FinishReason
+		}
+	}
+	return stats
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version and commit are set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=0.3.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Without -X the commit is taken from the VCS information Go embeds in
+// builds from a git checkout.
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// buildCommit returns the git commit wen was built from, or "unknown"
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		revision, modified := "", false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" && modified {
+			revision += " (有未提交的修改)"
+		}
+		if revision != "" {
+			return revision
+		}
+	}
+	return "unknown"
+}
+
+// printVersion implements --version
+func printVersion() {
+	fmt.Printf("wen %s\n", version)
+	fmt.Printf("Go 版本: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("Git 提交: %s\n", buildCommit())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// watchPollInterval is how often the watched file is checked
+	watchPollInterval = 500 * time.Millisecond
+	// watchDebounce is how long the file must stay unchanged before the
+	// prompt is re-sent, so a burst of saves triggers only one request
+	watchDebounce = 300 * time.Millisecond
+)
+
+// fileState identifies a version of the watched file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{info.ModTime(), info.Size()}, nil
+}
+
+// runWatch implements "wen watch <file> <prompt>": it sends the prompt with
+// the file's content, then re-sends it whenever the file changes until
+// interrupted with Ctrl-C. It returns the process exit code.
+func runWatch(args []string) int {
+	opts, words, err := parseArgs(args, nil)
+	if err != nil || len(words) < 2 {
+		fmt.Fprintln(os.Stderr, "使用方式: ./wen watch [选项] <文件> <问题>")
+		return 1
+	}
+	if err := startProfiling(opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer stopProfiling()
+	path, prompt := words[0], strings.Join(words[1:], " ")
+
+	config, err := loadDefaultConfig(opts.ConfigFile, opts.Profile, opts.EnvFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法加载配置文件: %v\n", err)
+		return 1
+	}
+	if err := applyOptions(config, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config.Silent = true
+
+	last, err := statFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法读取文件: %v\n", err)
+		return 1
+	}
+
+	for {
+		watchOnce(path, prompt, config)
+		last = waitForChange(path, last)
+	}
+}
+
+// watchOnce clears the screen and answers the prompt for the current file
+func watchOnce(path, prompt string, config *Config) {
+	if colorEnabled() {
+		fmt.Print("\033[H\033[2J")
+	}
+	fmt.Fprintln(os.Stderr, styleText("\033[1m", fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), path)))
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法读取文件: %v\n", err)
+		return
+	}
+	question := limitInput(fmt.Sprintf("%s\n\n文件 %s 的内容:\n%s", prompt, filepath.Base(path), content), config)
+
+	// Work on a copy so nothing accumulates in config across runs
+	c := *config
+	if c.Stream {
+		var answer string
+		answer, err = streamAI(question, &c)
+		endStreamedAnswer(answer)
+	} else {
+		var answer string
+		if answer, err = askAI(question, &c); err == nil {
+			printAnswer(answer, &c)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "请求AI失败: %v\n", err)
+	}
+	fmt.Fprintln(os.Stderr, styleText("\033[2m", "等待文件变化… (Ctrl-C 退出)"))
+}
+
+// waitForChange blocks until the file differs from last and has then been
+// stable for watchDebounce, and returns its new state
+func waitForChange(path string, last fileState) fileState {
+	for {
+		time.Sleep(watchPollInterval)
+		current, err := statFile(path)
+		if err != nil || current == last {
+			continue // Missing files are common mid-save with some editors
+		}
+
+		// Debounce: wait until saves stop
+		for {
+			time.Sleep(watchDebounce)
+			next, err := statFile(path)
+			if err == nil && next == current {
+				return current
+			}
+			if err == nil {
+				current = next
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultTerminalWidth is used when the width of the output is unknown
+const defaultTerminalWidth = 80
+
+// terminalWidth returns the width to wrap answers at: the size of the
+// terminal on stdout, else $COLUMNS, else defaultTerminalWidth
+func terminalWidth() int {
+	if w := ttyWidth(os.Stdout); w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return defaultTerminalWidth
+}
+
+// wrapEnabled reports whether answers are wrapped: as configured with wrap,
+// otherwise only when stdout is a terminal, so that text piped to other
+// programs keeps its lines
+func wrapEnabled(config *Config) bool {
+	if config.Wrap != nil {
+		return *config.Wrap
+	}
+	return isTerminal(os.Stdout)
+}
+
+// runeWidth returns the number of columns r takes up: 2 for East Asian wide
+// characters such as CJK ideographs and full-width punctuation, 1 otherwise
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// wrapText word-wraps the lines of text to width columns. Escape sequences
+// take up no room, and lines may break between any two wide characters since
+// CJK text has no spaces. Words longer than a line, such as URLs and
+// commands, are put on a line of their own rather than split. Lines of code
+// blocks are left as they are.
+func wrapText(text string, width int) string {
+	var b strings.Builder
+	inFence := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		if mdFence.MatchString(stripANSI(body)) {
+			inFence = !inFence
+		}
+		if inFence || displayWidth(stripANSI(body)) <= width {
+			b.WriteString(line)
+			continue
+		}
+		b.WriteString(wrapLine(body, width))
+		b.WriteString(line[len(body):])
+	}
+	return b.String()
+}
+
+// wrapLine wraps one line without its newline. Spaces at a break are dropped.
+func wrapLine(line string, width int) string {
+	var b strings.Builder
+	col := 0
+	space := "" // Spaces held back until the next word shows they fit
+	start := true
+
+	// breakLine ends the output line before a word
+	breakLine := func() {
+		b.WriteString("\n")
+		col, space = 0, ""
+	}
+	// word writes text without spaces, on a new line if it does not fit
+	word := func(text string, w int) {
+		if col > 0 && col+len(space)+w > width {
+			breakLine()
+		}
+		b.WriteString(space)
+		col += len(space) + w
+		space = ""
+		b.WriteString(text)
+	}
+
+	rest := line
+	for rest != "" {
+		if loc := ansiPattern.FindStringIndex(rest); loc != nil && loc[0] == 0 {
+			b.WriteString(rest[:loc[1]])
+			rest = rest[loc[1]:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case r == ' ' || r == '\t':
+			n := strings.IndexFunc(rest, func(r rune) bool { return r != ' ' && r != '\t' })
+			if n < 0 {
+				n = len(rest)
+			}
+			if start {
+				// Indentation is kept
+				b.WriteString(rest[:n])
+				col += len(rest[:n])
+			} else if col > 0 {
+				space = strings.ReplaceAll(rest[:n], "\t", " ")
+			}
+			rest = rest[n:]
+			continue
+		case runeWidth(r) == 2:
+			word(rest[:size], 2)
+			rest = rest[size:]
+		default:
+			n := strings.IndexFunc(rest, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == '\x1b' || runeWidth(r) == 2
+			})
+			if n < 0 {
+				n = len(rest)
+			} else if n == 0 {
+				n = size // An escape character that starts no sequence
+			}
+			word(rest[:n], displayWidth(rest[:n]))
+			rest = rest[n:]
+		}
+		start = false
+	}
+	return b.String()
+}
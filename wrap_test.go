@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		name, text string
+		width      int
+		want       string
+	}{
+		{"words", "one two three four", 9, "one two\nthree\nfour"},
+		{"indentation", "  one two three", 9, "  one two\nthree"},
+		{"CJK", "中文没有空格", 6, "中文没\n有空格"},
+		{"long word", "see https://example.com/a/very/long/path now", 12, "see\nhttps://example.com/a/very/long/path\nnow"},
+		{"long first word", "https://example.com/a/very/long/path", 12, "https://example.com/a/very/long/path"},
+		{"code block", "```\nname := os.Args[1] + os.Args[2]\n```", 10, "```\nname := os.Args[1] + os.Args[2]\n```"},
+		{"escape sequences", "\033[1mone\033[0m two three", 9, "\033[1mone\033[0m two\nthree"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapText(tt.text, tt.width); got != tt.want {
+				t.Errorf("wrapText(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapEnabled(t *testing.T) {
+	on, off := true, false
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	config := newConfig()
+	if wrapEnabled(config) {
+		t.Error("wrapping by default when stdout is not a terminal")
+	}
+	config.Wrap = &on
+	if !wrapEnabled(config) {
+		t.Error("wrap=true does not wrap")
+	}
+	config.Wrap = &off
+	if wrapEnabled(config) {
+		t.Error("wrap=false wraps")
+	}
+}